@@ -0,0 +1,65 @@
+package mdsplit
+
+import (
+	"strconv"
+	"strings"
+)
+
+// splitWithAffixes performs the split of SplitDetailed, adding the prefix and suffix of opts to every chunk.
+// The room they take depends on the amount of digits of the total, so the text is split reserving room for
+// a total of some digits first, and again with more of them if it grows past it, like the titles do.
+func splitWithAffixes(text string, max int, sep string, opts Options) SplitResult {
+	m := opts.LengthMode
+
+	if max <= 0 {
+		return splitChunks(text, max, sep, opts)
+	}
+
+	firstPrefix, continuationPrefix := opts.ChunkPrefix, opts.ChunkPrefix
+	if opts.FirstChunkPrefix != "" {
+		firstPrefix = opts.FirstChunkPrefix
+	}
+	if opts.ContinuationPrefix != "" {
+		continuationPrefix = opts.ContinuationPrefix
+	}
+
+	for digits := 1; ; digits++ {
+		reserved := strings.Repeat("9", digits)
+
+		suffixLen := m.measure(renderAffix(opts.ChunkSuffix, reserved, reserved))
+		firstLen := m.measure(renderAffix(firstPrefix, reserved, reserved)) + suffixLen
+		continuationLen := m.measure(renderAffix(continuationPrefix, reserved, reserved)) + suffixLen
+		if firstLen >= max || continuationLen >= max {
+			return SplitResult{Fallback: true, Reason: ReasonAffixesTooLong.String(), ReasonCode: ReasonAffixesTooLong}
+		}
+
+		result := splitChunks(text, max-continuationLen, sep, opts)
+
+		// the room of a longer first prefix is only reserved in all the chunks if the first one needs it
+		if firstLen > continuationLen && len(result.Chunks) > 0 && m.measure(result.Chunks[0])+firstLen > max {
+			result = splitChunks(text, max-firstLen, sep, opts)
+		}
+
+		total := strconv.Itoa(len(result.Chunks))
+		if len(total) > digits {
+			continue
+		}
+
+		for i, chunk := range result.Chunks {
+			prefix := continuationPrefix
+			if i == 0 {
+				prefix = firstPrefix
+			}
+
+			index := strconv.Itoa(i + 1)
+			result.Chunks[i] = renderAffix(prefix, index, total) + chunk + renderAffix(opts.ChunkSuffix, index, total)
+		}
+
+		return result
+	}
+}
+
+// renderAffix replaces the placeholders of the chunk prefix or suffix by the index and the total.
+func renderAffix(affix, index, total string) string {
+	return strings.NewReplacer("{i}", index, "{n}", total).Replace(affix)
+}
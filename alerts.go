@@ -0,0 +1,26 @@
+package mdsplit
+
+import "regexp"
+
+var alertMarkerRe = regexp.MustCompile(`^\[!(?i:NOTE|TIP|IMPORTANT|WARNING|CAUTION)\]\n`)
+
+// alertMarker returns the marker (like [!WARNING]) the text begins with if it's the first one of a GitHub alert,
+// a blockquote beginning with the marker in a line of its own.
+func alertMarker(text *mdNode) string {
+	paragraph := text.parent
+	if paragraph == nil || paragraph.typ != paragraphNode || paragraph.firstChild != text {
+		return ""
+	}
+
+	quote := paragraph.parent
+	if quote == nil || quote.typ != blockQuoteNode || quote.firstChild != paragraph {
+		return ""
+	}
+
+	marker := alertMarkerRe.FindString(string(text.literal))
+	if marker == "" {
+		return ""
+	}
+
+	return marker[:len(marker)-1]
+}
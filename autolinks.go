@@ -0,0 +1,47 @@
+package mdsplit
+
+import (
+	"regexp"
+	"strings"
+)
+
+var autolinkRe = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9+.\-]{1,31}:[^<>\s]*|[^<>\s@]+@[^<>\s@]+)>`)
+
+// findAutolinks looks for the links written between angle brackets in the text, keyed by the
+// destination blackfriday gives them, and holding the original text between the brackets.
+func findAutolinks(text string) map[string]string {
+	autolinks := map[string]string{}
+
+	for _, match := range autolinkRe.FindAllStringSubmatch(text, -1) {
+		// emails get their scheme from blackfriday
+		destination := match[1]
+		if !strings.Contains(destination, ":") {
+			destination = "mailto:" + destination
+		}
+
+		autolinks[destination] = match[1]
+	}
+
+	return autolinks
+}
+
+// autolinkText returns the original form of the link if it was written between angle brackets.
+// The link text of an autolink is always its own destination, with no "mailto:" in emails.
+func autolinkText(node *mdNode, autolinks map[string]string) (string, bool) {
+	original, ok := autolinks[string(node.destination)]
+	if !ok {
+		return "", false
+	}
+
+	child := node.firstChild
+	if child == nil || child.next != nil || child.typ != textNode {
+		return "", false
+	}
+
+	destination := strings.TrimPrefix(string(node.destination), "mailto:")
+	if string(child.literal) != destination {
+		return "", false
+	}
+
+	return "<" + original + ">", true
+}
@@ -0,0 +1,135 @@
+// Command md-split splits a markdown text in chunks no longer than a maximum length, keeping the
+// markdown of every chunk valid on its own.
+//
+// The text is read from the file given as argument, or from stdin if there's none, and the chunks
+// are written to stdout separated by a delimiter, or to numbered files in a directory.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	mdsplit "github.com/rarguellof/md-split"
+)
+
+// exit codes
+const (
+	exitOK = iota
+	exitError
+	exitFallback
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command with the given arguments, returning its exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("md-split", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	max := flags.Int("max", 0, "maximum length of every chunk")
+	sep := flags.String("sep", "", "separator appended to every chunk but the last one")
+	github := flags.Bool("github", false, "use the maximum length of a GitHub comment, instead of -max")
+	delim := flags.String("delim", "\n----\n", "delimiter written between the chunks to stdout")
+	outDir := flags.String("out-dir", "", "directory to write every chunk to a numbered file in, instead of stdout")
+	strict := flags.Bool("strict", false, "exit with code 2 if the text can't be split as markdown and a simple split is done")
+
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: md-split [flags] [file]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+
+	if *github {
+		*max = mdsplit.MaxGithubCommentSize
+	}
+
+	if *max <= 0 || flags.NArg() > 1 {
+		flags.Usage()
+		return exitError
+	}
+
+	text, err := readText(flags.Arg(0), stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, "md-split:", err)
+		return exitError
+	}
+
+	result := mdsplit.SplitDetailed(text, *max, *sep, mdsplit.DefaultOptions())
+	if result.Chunks == nil {
+		fmt.Fprintln(stderr, "md-split: the separator doesn't fit in a chunk")
+		return exitError
+	}
+
+	if *outDir != "" {
+		err = writeFiles(*outDir, result.Chunks)
+	} else {
+		err = writeChunks(stdout, result.Chunks, *delim)
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, "md-split:", err)
+		return exitError
+	}
+
+	if result.Fallback && *strict {
+		fmt.Fprintf(stderr, "md-split: the text was split as plain text: %s\n", result.Reason)
+		return exitFallback
+	}
+
+	return exitOK
+}
+
+// readText reads the text from the file, or from stdin if there's none.
+func readText(file string, stdin io.Reader) (string, error) {
+	var content []byte
+	var err error
+
+	if file == "" {
+		content, err = io.ReadAll(stdin)
+	} else {
+		content, err = os.ReadFile(file)
+	}
+
+	return string(content), err
+}
+
+// writeChunks writes the chunks to w, separated by delim.
+func writeChunks(w io.Writer, chunks []string, delim string) error {
+	for i, chunk := range chunks {
+		if i > 0 {
+			if _, err := io.WriteString(w, delim); err != nil {
+				return err
+			}
+		}
+
+		if _, err := io.WriteString(w, chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeFiles writes every chunk to a file in dir named after its number, like 1.md, creating dir if needed.
+func writeFiles(dir string, chunks []string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	for i, chunk := range chunks {
+		path := filepath.Join(dir, fmt.Sprintf("%d.md", i+1))
+		if err := os.WriteFile(path, []byte(chunk), 0o644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
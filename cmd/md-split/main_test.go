@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	mdsplit "github.com/rarguellof/md-split"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommand(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "md-split")
+
+	build := exec.Command("go", "build", "-o", bin, ".")
+	out, err := build.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	input, err := os.ReadFile(filepath.Join("testdata", "input.md"))
+	require.NoError(t, err)
+
+	chunks, ok := mdsplit.MarkdownSplit(string(input), 90, "")
+	require.True(t, ok)
+
+	t.Run("stdout", func(t *testing.T) {
+		out, err := exec.Command(bin, "-max", "90", "-delim", "\n====\n", filepath.Join("testdata", "input.md")).Output()
+		require.NoError(t, err)
+		assert.Equal(t, strings.Join(chunks, "\n====\n"), string(out))
+	})
+
+	t.Run("out-dir", func(t *testing.T) {
+		outDir := filepath.Join(dir, "chunks")
+
+		cmd := exec.Command(bin, "-max", "90", "-out-dir", outDir)
+		cmd.Stdin = strings.NewReader(string(input))
+		require.NoError(t, cmd.Run())
+
+		for i, chunk := range chunks {
+			content, err := os.ReadFile(filepath.Join(outDir, strconv.Itoa(i+1)+".md"))
+			require.NoError(t, err)
+			assert.Equal(t, chunk, string(content))
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		// lists aren't split, so the simple split is done instead
+		cmd := exec.Command(bin, "-max", "15", "-strict")
+		cmd.Stdin = strings.NewReader("* a list\n* with two items\n")
+
+		var exitErr *exec.ExitError
+		_, err := cmd.Output()
+		require.True(t, errors.As(err, &exitErr))
+		assert.Equal(t, exitFallback, exitErr.ExitCode())
+	})
+
+	t.Run("no max", func(t *testing.T) {
+		var exitErr *exec.ExitError
+		err := exec.Command(bin, filepath.Join("testdata", "input.md")).Run()
+		require.True(t, errors.As(err, &exitErr))
+		assert.Equal(t, exitError, exitErr.ExitCode())
+	})
+}
@@ -0,0 +1,23 @@
+package mdsplit
+
+import "strings"
+
+// detailsSummary renders the contents of the summary of a collapsible section, given its opening tag.
+// Returns them along with the nodes they're made of, including the closing tag.
+func detailsSummary(open *mdNode) (string, []*mdNode) {
+	var sb strings.Builder
+	var nodes []*mdNode
+
+	for n := open.next; n != nil; n = n.next {
+		nodes = append(nodes, n)
+
+		if n.typ == htmlSpanNode && getHTMLTagName(string(n.literal)) == "summary" &&
+			strings.HasPrefix(string(n.literal), "</") {
+			break
+		}
+
+		sb.WriteString(renderInlineNode(n))
+	}
+
+	return sb.String(), nodes
+}
@@ -0,0 +1,36 @@
+package mdsplit
+
+import (
+	"regexp"
+	"strings"
+)
+
+// the longest entity is shorter than this, so there is no need to look further back for its beginning
+const maxEntityLen = 33
+
+var entityRe = regexp.MustCompile(`^&(?:[a-zA-Z][a-zA-Z0-9]{1,31}|#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6});`)
+
+// cutOutsideEntities moves the cut index of s back to the beginning of the html entity (like &amp;
+// or &#x1F600;) it falls in, if any. The index is kept if the entity starts s, so callers still make progress.
+func cutOutsideEntities(s string, idx int) int {
+	from := idx - maxEntityLen
+	if from < 0 {
+		from = 0
+	}
+
+	start := strings.LastIndexByte(s[from:idx], '&')
+	if start == -1 {
+		return idx
+	}
+
+	start += from
+	if start == 0 {
+		return idx
+	}
+
+	if loc := entityRe.FindStringIndex(s[start:]); loc != nil && start+loc[1] > idx {
+		return start
+	}
+
+	return idx
+}
@@ -0,0 +1,101 @@
+package mdsplit
+
+import (
+	"regexp"
+	"strings"
+)
+
+// fences may be nested in blockquotes and list items, so any indentation and quote markers are allowed, along with
+// the markers of the list items the opening ones may begin
+var fenceRe = regexp.MustCompile("^[ >]*((?:(?:[-*+]|\\d{1,9}[.)]) +)*)(`{3,}|~{3,})")
+
+// findCodeFences looks for the opening fence of every fenced code block in the text, in order of appearance,
+// as blackfriday doesn't keep which character they were made of.
+func findCodeFences(text string) []string {
+	fences, _ := walkCodeFences(text, false, nil)
+	return fences
+}
+
+// scanCodeFences looks for the opening fences like findCodeFences, also returning the one left open at the end
+// of the text, if any. Unlike blackfriday, it takes the fences right after the marker of a list item, like
+// "1. ```", as opening ones, as most platforms render them so.
+func scanCodeFences(text string) ([]string, string) {
+	return walkCodeFences(text, true, nil)
+}
+
+// walkCodeFences scans the text like findCodeFences, or like scanCodeFences if afterMarkers is set, calling
+// outside, if it isn't nil, with every line that isn't a fence or part of a fenced code block.
+func walkCodeFences(text string, afterMarkers bool, outside func(line string)) ([]string, string) {
+	var fences []string
+	open := ""
+
+	for _, line := range strings.Split(text, "\n") {
+		match := fenceRe.FindStringSubmatch(line)
+		if match != nil && match[1] != "" && (!afterMarkers || open != "") {
+			match = nil
+		}
+
+		if match == nil {
+			if open == "" && outside != nil {
+				outside(line)
+			}
+			continue
+		}
+
+		fence := match[2]
+
+		if open == "" {
+			// backtick fences can't have backticks in their info string
+			if fence[0] == '`' && strings.Contains(line[len(match[0]):], "`") {
+				continue
+			}
+
+			open = fence
+			fences = append(fences, fence)
+			continue
+		}
+
+		// the closing fence must be made of the same character, be at least as long, and have nothing else
+		if fence[0] == open[0] && len(fence) >= len(open) && strings.TrimSpace(line[len(match[0]):]) == "" {
+			open = ""
+		}
+	}
+
+	return fences, open
+}
+
+// fenceFor lengthens the fence so it's longer than any run of its character in the contents,
+// otherwise the code would be closing its own block.
+func fenceFor(fence, contents string) string {
+	longest, run := 0, 0
+
+	for i := 0; i < len(contents); i++ {
+		if contents[i] != fence[0] {
+			run = 0
+			continue
+		}
+
+		run++
+		if run > longest {
+			longest = run
+		}
+	}
+
+	if longest < len(fence) {
+		return fence
+	}
+
+	return strings.Repeat(fence[:1], longest+1)
+}
+
+// codeSpanWrapper returns the wrapper of a code span with the contents, made of a run of backticks longer than any
+// run of them in the contents. If there's any, they are kept apart from it by a space, which the platforms strip,
+// as any part of the contents may begin or end with a backtick once cut.
+func codeSpanWrapper(contents string) *wrapper {
+	fence := fenceFor("`", contents)
+	if !strings.Contains(contents, "`") {
+		return &wrapper{begin: fence, end: fence}
+	}
+
+	return &wrapper{begin: fence + " ", end: " " + fence}
+}
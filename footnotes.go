@@ -0,0 +1,77 @@
+package mdsplit
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// the definitions may come after the markers of the list items or block quotes they are in
+	footnoteDefinitionRe = regexp.MustCompile(`^(?:[ \t]*(?:[*+-]|\d{1,9}[.)]|>))*[ \t]*\[\^([^\]\s]+)\]:`)
+	footnoteReferenceRe  = regexp.MustCompile(`\[\^([^\]\s]+)\]`)
+)
+
+// hasFootnoteCycle tells whether some footnote definition of the text references itself, directly or through
+// other definitions, which makes blackfriday recurse forever. The definitions are taken up to a blank line
+// followed by a line that isn't indented, so in case of doubt, they are taken as if they had a cycle.
+func hasFootnoteCycle(text string) bool {
+	refs := map[string][]string{}
+
+	label := ""
+	blank := false
+	for _, line := range strings.Split(text, "\n") {
+		if match := footnoteDefinitionRe.FindStringSubmatch(line); match != nil {
+			label = strings.ToLower(match[1])
+			line = line[len(match[0]):]
+		} else if strings.TrimSpace(line) == "" {
+			blank = true
+			continue
+		} else if blank && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			label = ""
+		}
+
+		blank = false
+		if label == "" {
+			continue
+		}
+
+		for _, ref := range footnoteReferenceRe.FindAllStringSubmatch(line, -1) {
+			refs[label] = append(refs[label], strings.ToLower(ref[1]))
+		}
+	}
+
+	// depth-first search, where the definitions being visited are in progress
+	const (
+		inProgress = 1
+		done       = 2
+	)
+	state := map[string]int{}
+
+	var visit func(label string) bool
+	visit = func(label string) bool {
+		switch state[label] {
+		case inProgress:
+			return true
+		case done:
+			return false
+		}
+
+		state[label] = inProgress
+		for _, ref := range refs[label] {
+			if visit(ref) {
+				return true
+			}
+		}
+		state[label] = done
+
+		return false
+	}
+
+	for label := range refs {
+		if visit(label) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,88 @@
+package mdsplit
+
+import (
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+)
+
+// LengthMode defines the unit used to measure the length of the text and the chunks.
+type LengthMode int
+
+const (
+	// Bytes measures the length in bytes, like len does. Chunks are never cut in the middle of a rune.
+	Bytes LengthMode = iota
+
+	// Graphemes measures the length in user-perceived characters (grapheme clusters),
+	// so a flag or a ZWJ emoji sequence counts as one character and is never cut.
+	Graphemes
+
+	// DisplayWidth measures the length in monospace columns, as terminals do,
+	// so wide characters (like full-width CJK ones) count as two.
+	DisplayWidth
+)
+
+// measure returns the length of s in the unit of the mode.
+func (m LengthMode) measure(s string) int {
+	switch m {
+	case Graphemes:
+		return uniseg.GraphemeClusterCount(s)
+	case DisplayWidth:
+		return uniseg.StringWidth(s)
+	default:
+		return len(s)
+	}
+}
+
+// cut returns the byte index where s has to be cut so the portion before it measures n at most,
+// without breaking any unit of the mode. It always returns a positive index if s isn't empty,
+// even if it has to break a unit, so callers are guaranteed to make progress.
+func (m LengthMode) cut(s string, n int) int {
+	if n < 1 {
+		n = 1
+	}
+
+	switch m {
+	case Graphemes:
+		idx, state := 0, -1
+		for count := 0; count < n && idx < len(s); count++ {
+			var cluster string
+			cluster, _, _, state = uniseg.FirstGraphemeClusterInString(s[idx:], state)
+			idx += len(cluster)
+		}
+
+		return idx
+
+	case DisplayWidth:
+		idx, width, state := 0, 0, -1
+		for idx < len(s) {
+			cluster, _, w, newState := uniseg.FirstGraphemeClusterInString(s[idx:], state)
+			if width+w > n && idx > 0 {
+				break
+			}
+
+			idx += len(cluster)
+			width += w
+			state = newState
+		}
+
+		return idx
+
+	default:
+		if n >= len(s) {
+			return len(s)
+		}
+
+		// back off to the beginning of the rune, so it doesn't get broken
+		idx := n
+		for idx > 0 && !utf8.RuneStart(s[idx]) {
+			idx--
+		}
+
+		if idx == 0 {
+			return n
+		}
+
+		return idx
+	}
+}
@@ -0,0 +1,69 @@
+package mdsplit
+
+import "strings"
+
+// LineSplit splits the text in chunks made of whole lines, filling every chunk with as many of them as fit, for
+// texts like logs where a line cut in half is useless. The line breaks are kept at the end of every line.
+//
+// Returns false if a line doesn't fit in a chunk on its own, in which case it's cut like SimpleSplit does.
+// Returns nil if max isn't positive or the separator doesn't leave room for anything else in a chunk.
+func LineSplit(text string, max int, sep string) ([]string, bool) {
+	if max <= 0 {
+		return nil, false
+	}
+
+	if text == "" {
+		return []string{}, true
+	}
+
+	// If we're under the limit then no need to split.
+	if len(text) <= max {
+		return []string{text}, true
+	}
+
+	// If we can't fit the separator string in then this doesn't make sense.
+	if max <= len(sep) {
+		return nil, false
+	}
+
+	maxSize := max - len(sep)
+	ok := true
+
+	var pieces []string
+	var cur strings.Builder
+
+	flush := func() {
+		if cur.Len() > 0 {
+			pieces = append(pieces, cur.String())
+			cur.Reset()
+		}
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line == "" {
+			continue
+		}
+
+		if len(line) > maxSize {
+			ok = false
+			flush()
+
+			pieces = append(pieces, SimpleSplit(line, maxSize, "")...)
+			continue
+		}
+
+		if cur.Len()+len(line) > maxSize {
+			flush()
+		}
+
+		cur.WriteString(line)
+	}
+
+	flush()
+
+	for i := range pieces[:len(pieces)-1] {
+		pieces[i] += sep
+	}
+
+	return pieces, ok
+}
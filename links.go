@@ -0,0 +1,71 @@
+package mdsplit
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// linkSpanRe matches the syntax of a link, like [text], [text](url "title"), [text][ref] or ![alt](src)
+var linkSpanRe = regexp.MustCompile(`!?\[[^\[\]]*\](?:\([^()]*\)|\[[^\[\]]*\])?`)
+
+// findLinkSpans returns the byte ranges of the links in the text, in order.
+func findLinkSpans(text string) [][]int {
+	return linkSpanRe.FindAllStringIndex(text, -1)
+}
+
+// cutOutsideLinks moves the cut index of a portion of the text beginning at offset back to the beginning
+// of the link it falls in, if any, given the spans of the links in the whole text. The index is kept if the
+// link begins the portion, as it can't fit anyway.
+func cutOutsideLinks(spans [][]int, offset, idx int) int {
+	pos := offset + idx
+
+	i := sort.Search(len(spans), func(i int) bool { return spans[i][1] > pos })
+	if i < len(spans) && spans[i][0] < pos && spans[i][0] > offset {
+		return spans[i][0] - offset
+	}
+
+	return idx
+}
+
+// escapeLinkTitle escapes the double quotes of a link title, so it can be written between them. The
+// parser keeps the escapes of the title as they are, so the quotes already escaped are left alone.
+func escapeLinkTitle(title string) string {
+	var sb strings.Builder
+
+	escaped := false
+	for _, r := range title {
+		if r == '"' && !escaped {
+			sb.WriteByte('\\')
+		}
+
+		escaped = r == '\\' && !escaped
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
+
+// unresolvedReferenceRe matches a reference-style link left as text, like [text][label] or [text][], which
+// happens when there's no definition for its label
+var unresolvedReferenceRe = regexp.MustCompile(`\[[^\[\]]*\]\[[^\[\]]*\]`)
+
+// wikiLinkRe matches a wiki-style link, like [[Page]] or [[Page|Alias]], which the parser leaves as text
+var wikiLinkRe = regexp.MustCompile(`\[\[[^\[\]\n]+\]\]`)
+
+// unresolvedReferenceOrWikiLinkRe matches either an unresolved reference-style link or a wiki-style link
+var unresolvedReferenceOrWikiLinkRe = regexp.MustCompile(unresolvedReferenceRe.String() + "|" + wikiLinkRe.String())
+
+// splitTextLinks splits the text of a text node around the links left in it matched by re, which go
+// at the odd indexes.
+func splitTextLinks(s string, re *regexp.Regexp) []string {
+	var parts []string
+	last := 0
+
+	for _, idx := range re.FindAllStringIndex(s, -1) {
+		parts = append(parts, s[last:idx[0]], s[idx[0]:idx[1]])
+		last = idx[1]
+	}
+
+	return append(parts, s[last:])
+}
@@ -0,0 +1,127 @@
+package mdsplit
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var taskCheckboxRe = regexp.MustCompile(`^\[[ xX]\] `)
+
+// ordered list items may be nested in blockquotes and other list items, so any indentation and quote markers are allowed
+var orderedItemRe = regexp.MustCompile(`^[ \t>]*(\d{1,9})[.)](?:[ \t]|$)`)
+
+// findListStarts looks for the number every ordered list of the document begins with, as blackfriday doesn't keep
+// it. The numbers of the items are found in the text in order of appearance, like the fences of the code blocks,
+// and matched with the ordered items of the document. If they don't match, like when a line that looks like an
+// item isn't one, none is returned and the lists are numbered from 1.
+func findListStarts(text string, doc *mdNode) map[*mdNode]int {
+	var numbers []int
+	walkCodeFences(text, false, func(line string) {
+		if match := orderedItemRe.FindStringSubmatch(line); match != nil {
+			n, _ := strconv.Atoi(match[1])
+			numbers = append(numbers, n)
+		}
+	})
+
+	if len(numbers) == 0 {
+		return nil
+	}
+
+	var items []*mdNode
+	doc.walk(func(node *mdNode, entering bool) walkStatus {
+		if entering && node.typ == itemNode && node.ordered && !node.parent.footnotesList {
+			items = append(items, node)
+		}
+
+		return goToNext
+	})
+
+	if len(items) != len(numbers) {
+		return nil
+	}
+
+	starts := map[*mdNode]int{}
+	for i, item := range items {
+		if item.prev == nil {
+			starts[item.parent] = numbers[i]
+		}
+	}
+
+	return starts
+}
+
+// itemMarker returns the marker of a list item, like "- " or "2. ", given the number every ordered list begins
+// with, if it isn't 1.
+func itemMarker(item *mdNode, starts map[*mdNode]int) string {
+	if !item.ordered {
+		bullet := item.bulletChar
+		if bullet == 0 {
+			bullet = '-'
+		}
+
+		return string(bullet) + " "
+	}
+
+	num, ok := starts[item.parent]
+	if !ok {
+		num = 1
+	}
+
+	for prev := item.prev; prev != nil; prev = prev.prev {
+		num++
+	}
+
+	delim := item.delimiter
+	if delim == 0 {
+		delim = '.'
+	}
+
+	return strconv.Itoa(num) + string(delim) + " "
+}
+
+// itemIndentation returns the indentation needed to nest the contents of item
+// under all the list items containing it.
+func itemIndentation(item *mdNode, starts map[*mdNode]int) string {
+	indent := ""
+
+	for parent := item.parent; parent != nil; parent = parent.parent {
+		if parent.typ == itemNode {
+			indent += strings.Repeat(" ", len(itemMarker(parent, starts)))
+		}
+	}
+
+	return indent
+}
+
+// itemContinuationIndentation returns the indentation needed to keep the paragraphs of item after
+// its first one in it. Blackfriday needs at least four spaces, and its marker may be longer.
+func itemContinuationIndentation(item *mdNode, starts map[*mdNode]int) string {
+	width := len(itemMarker(item, starts))
+	if width < 4 {
+		width = 4
+	}
+
+	return itemIndentation(item, starts) + strings.Repeat(" ", width)
+}
+
+// itemCheckbox returns the text node holding the checkbox of a task list item,
+// along with the checkbox itself. If it's not a task list item, node is nil.
+func itemCheckbox(item *mdNode) (*mdNode, string) {
+	paragraph := item.firstChild
+	if paragraph == nil || paragraph.typ != paragraphNode {
+		return nil, ""
+	}
+
+	text := paragraph.firstChild
+	if text == nil || text.typ != textNode {
+		return nil, ""
+	}
+
+	checkbox := taskCheckboxRe.FindString(string(text.literal))
+	if checkbox == "" {
+		return nil, ""
+	}
+
+	return text, checkbox
+}
@@ -0,0 +1,85 @@
+package mdsplit
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	displayMathRe = regexp.MustCompile(`(?s)\$\$.+?\$\$`)
+	// inline math can't begin or end with whitespace, so amounts like "$5 and $10" aren't mistaken for it
+	inlineMathRe = regexp.MustCompile(`\$[^\s$](?:[^$\n]*[^\s$])?\$`)
+)
+
+// mathExpressions holds the math expressions taken out of a text before parsing it, so the parser
+// doesn't mistake their contents for markdown. Every one of them is replaced by a placeholder made of
+// an anchor not found in the text and its index.
+type mathExpressions struct {
+	anchor        string
+	placeholderRe *regexp.Regexp
+	expressions   []string
+}
+
+// extractMath replaces the display ($$...$$) and inline ($...$) math expressions of the text by placeholders.
+func extractMath(text string) (string, *mathExpressions) {
+	anchor := strings.Trim(genTextAnchor(text), "<>")
+	e := &mathExpressions{
+		anchor:        anchor,
+		placeholderRe: regexp.MustCompile(anchor + `(\d+)M`),
+	}
+
+	replace := func(expression string) string {
+		e.expressions = append(e.expressions, expression)
+		return e.anchor + strconv.Itoa(len(e.expressions)-1) + "M"
+	}
+
+	text = displayMathRe.ReplaceAllStringFunc(text, replace)
+	text = inlineMathRe.ReplaceAllStringFunc(text, replace)
+
+	return text, e
+}
+
+// restore puts back the original math expressions in place of their placeholders.
+func (e *mathExpressions) restore(s string) string {
+	if e == nil || len(e.expressions) == 0 {
+		return s
+	}
+
+	return e.placeholderRe.ReplaceAllStringFunc(s, func(placeholder string) string {
+		return e.expressions[e.index(placeholder)]
+	})
+}
+
+// in tells whether s has any of the placeholders.
+func (e *mathExpressions) in(s string) bool {
+	return e != nil && len(e.expressions) > 0 && strings.Contains(s, e.anchor)
+}
+
+// split splits s around the placeholders, restoring their math expressions.
+// Even positions of the result are text and odd ones are math expressions.
+func (e *mathExpressions) split(s string) []string {
+	if e == nil || len(e.expressions) == 0 {
+		return []string{s}
+	}
+
+	var parts []string
+	last := 0
+
+	for _, idx := range e.placeholderRe.FindAllStringIndex(s, -1) {
+		parts = append(parts, s[last:idx[0]], e.expressions[e.index(s[idx[0]:idx[1]])])
+		last = idx[1]
+	}
+
+	return append(parts, s[last:])
+}
+
+func (e *mathExpressions) index(placeholder string) int {
+	i, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(placeholder, e.anchor), "M"))
+	return i
+}
+
+// isDisplayMath tells whether the math expression is a display one, which goes in its own block.
+func isDisplayMath(expression string) bool {
+	return strings.HasPrefix(expression, "$$")
+}
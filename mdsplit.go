@@ -1,28 +1,76 @@
 package mdsplit
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
+	"regexp"
 	"strconv"
 	"strings"
-	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/russross/blackfriday/v2"
 )
 
+// The max sizes of the texts of some platforms, which are measured in characters, so they are never exceeded
+// measuring them in bytes either.
 const (
+	// MaxGithubCommentSize is the max size of a comment of an issue or a pull request of GitHub.
 	MaxGithubCommentSize = 65536
+	// MaxGithubIssueBodySize is the max size of the description of an issue or a pull request of GitHub.
+	MaxGithubIssueBodySize = 65536
+	// MaxGitlabCommentSize is the max size of a comment (a note) of GitLab.
+	MaxGitlabCommentSize = 1000000
+	// MaxJiraCommentSize is the max size of a comment of Jira.
+	MaxJiraCommentSize = 32767
+	// MaxSlackMessageSize is the max size of the text of a message of Slack.
+	MaxSlackMessageSize = 40000
+	// MaxDiscordMessageSize is the max size of a message of Discord.
+	MaxDiscordMessageSize = 2000
 )
 
 type wrapper struct {
 	begin string
 	end   string
+
+	// definition is appended at the end of every chunk using the wrapper, as it's needed
+	// to render it (like the definition of a reference-style link)
+	definition string
 }
 
 type chunk struct {
 	content  string
 	wrappers []*wrapper
+
+	// newChunk forces the chunk to begin a new output chunk, instead of being merged with the previous one
+	newChunk bool
+
+	// title overrides the base title when the chunk begins a new output chunk
+	title string
+
+	// max limits the length of the output chunk the chunk goes in below the max of the split, if it isn't 0
+	max int
+
+	// joint goes before the content when the chunk is merged with the previous one, as it only
+	// separates them (like the blank line between the paragraphs of a list item)
+	joint string
+
+	// section is the number of the section the chunk is in, when the titles are numbered per section
+	section int
+
+	// wholeLen is the length of the paragraph the chunk begins, assembled on its own, when it's kept whole. The
+	// chunk begins a new output chunk then, unless the whole paragraph fits in the current one.
+	wholeLen int
+
+	// midParagraph tells the chunk continues the paragraph of the previous one
+	midParagraph bool
+
+	// oversized tells the chunk holds a url kept whole with KeepURLsWhole, in an output chunk of its own that may
+	// be longer than max
+	oversized bool
 }
 
 // SplitGithubComment is an alias of MarkdownSplit using MaxGithubCommentSize.
@@ -30,285 +78,2791 @@ func SplitGithubComment(text, sep string) ([]string, bool) {
 	return MarkdownSplit(text, MaxGithubCommentSize, sep)
 }
 
+// SplitGithubIssueBody is an alias of MarkdownSplit using MaxGithubIssueBodySize.
+func SplitGithubIssueBody(text, sep string) ([]string, bool) {
+	return MarkdownSplit(text, MaxGithubIssueBodySize, sep)
+}
+
+// SplitGitlabComment is an alias of MarkdownSplit using MaxGitlabCommentSize.
+func SplitGitlabComment(text, sep string) ([]string, bool) {
+	return MarkdownSplit(text, MaxGitlabCommentSize, sep)
+}
+
+// SplitJiraComment is an alias of MarkdownSplit using MaxJiraCommentSize.
+func SplitJiraComment(text, sep string) ([]string, bool) {
+	return MarkdownSplit(text, MaxJiraCommentSize, sep)
+}
+
+// SplitSlackMessage is an alias of MarkdownSplit using MaxSlackMessageSize.
+func SplitSlackMessage(text, sep string) ([]string, bool) {
+	return MarkdownSplit(text, MaxSlackMessageSize, sep)
+}
+
+// SplitDiscordMessage is an alias of MarkdownSplit using MaxDiscordMessageSize.
+func SplitDiscordMessage(text, sep string) ([]string, bool) {
+	return MarkdownSplit(text, MaxDiscordMessageSize, sep)
+}
+
 // MarkdownSplit tries to perform a markdown split based on max length and a separator string,
 // preserving markdown syntax on the chunked splits as much as possible.
 // If it's not possible, it fallbacks to simple split method.
+// An empty or whitespace-only text has nothing to render, so it's split in no chunks at all.
+// A max that isn't positive makes no sense, so nil and false are returned.
 //
 // Returns the text splits and a bool informing if it was able to do markdown split successfully or not.
 func MarkdownSplit(text string, max int, sep string) ([]string, bool) {
-	// If we're under the limit then no need to split.
-	if len(text) <= max {
-		return []string{text}, true
-	}
+	return MarkdownSplitOpts(text, max, sep, DefaultOptions())
+}
 
-	// If we can't fit the separator string in then this doesn't make sense.
-	if max <= len(sep) {
-		return nil, false
+// MarkdownSplitOpts is like MarkdownSplit, but allows to customize its behavior with opts.
+func MarkdownSplitOpts(text string, max int, sep string, opts Options) ([]string, bool) {
+	result := SplitDetailed(text, max, sep, opts)
+	return result.Chunks, !result.Fallback && result.Err == nil
+}
+
+// MarkdownSplitReason is like MarkdownSplit, but returns why the markdown split wasn't possible instead of
+// a bool, which is SplitOK if it was.
+func MarkdownSplitReason(text string, max int, sep string) ([]string, SplitReason) {
+	result := SplitDetailed(text, max, sep, DefaultOptions())
+	return result.Chunks, result.ReasonCode
+}
+
+// MarkdownSplitFunc is like MarkdownSplit, but calls fn with every chunk, along with its index (starting at 0)
+// and the total amount of chunks, as soon as it's assembled instead of returning them, so they aren't all kept
+// at once. The titles need the total, so the chunks are counted before calling fn for the first one. If the
+// markdown split isn't possible, fn gets the chunks of the simple split, the same ones MarkdownSplit returns.
+//
+// Returns the error returned by fn, if any, which stops calling it for the rest of the chunks.
+func MarkdownSplitFunc(text string, max int, sep string, fn func(index, total int, chunk string) error) error {
+	_, err := splitFunc(text, max, sep, DefaultOptions(), fn)
+	return err
+}
+
+// splitFunc performs the split of SplitDetailed, calling fn with every chunk like MarkdownSplitFunc. The
+// chunks are emitted as they are assembled unless opts needs all of them to change them afterwards, or it
+// fallbacks to a simple split, in which case fn is called with them once the split is over.
+// Returns the result of the split, without the chunks when they were emitted, and the error returned by fn.
+func splitFunc(text string, max int, sep string, opts Options, fn func(index, total int, chunk string) error) (SplitResult, error) {
+	called := false
+	var err error
+
+	if canEmit(opts) {
+		opts.emit = func(index, total int, chunk string) error {
+			called = true
+			err = fn(index, total, chunk)
+			return err
+		}
 	}
 
-	var chunks []*chunk
-	baseTitle := ""
-	titleLen := 0
-	titleSuffixFmt := " (%d/%s)\n\n"
-	canSplit := true
+	result := SplitDetailed(text, max, sep, opts)
 
-	var htmlWrappers []*wrapper
+	if !called {
+		for i, chunk := range result.Chunks {
+			if err = fn(i, len(result.Chunks), chunk); err != nil {
+				break
+			}
+		}
+	}
+
+	return result, err
+}
 
-	md := blackfriday.New(blackfriday.WithExtensions(blackfriday.Strikethrough))
-	rootNode := md.Parse([]byte(text))
+// canEmit tells whether the chunks can be emitted as they are assembled with opts, as none of them
+// is changed once all of them are known.
+func canEmit(opts Options) bool {
+	return opts.ChunkPrefix == "" && opts.ChunkSuffix == "" && opts.FirstChunkPrefix == "" && opts.ContinuationPrefix == "" &&
+		opts.OutputLineEnding == LF && !opts.RestoreCRLF && !opts.TrimChunks && opts.MaxChunks == 0 &&
+		!opts.Balanced && !opts.Rebalance && !opts.PreserveEdgeWhitespace
+}
 
-	rootNode.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
-		switch node.Type {
-		case blackfriday.List:
-			// TODO: change when lists are actually implemented
-			canSplit = false
-			return blackfriday.Terminate
+// SplitToWriter is like MarkdownSplit, but writes the chunks to w separated by delim as soon as they are
+// assembled, like MarkdownSplitFunc, instead of returning them.
+//
+// Returns the amount of chunks written, whether the markdown split was possible, and the error of the
+// first write that failed, if any, which stops writing the rest of the chunks.
+func SplitToWriter(w io.Writer, text string, max int, sep string, delim string) (int, bool, error) {
+	written := 0
+
+	result, err := splitFunc(text, max, sep, DefaultOptions(), func(index, total int, chunk string) error {
+		if index > 0 {
+			if _, err := io.WriteString(w, delim); err != nil {
+				return err
+			}
 		}
 
-		if node.Literal == nil {
-			return blackfriday.GoToNext
+		if _, err := io.WriteString(w, chunk); err != nil {
+			return err
 		}
 
-		contents := string(node.Literal)
-		var wrappers []*wrapper
+		written++
 
-		parent := node.Parent
-		for parent != nil {
-			switch parent.Type {
-			case blackfriday.Del:
-				wrappers = append(wrappers, &wrapper{begin: "~~", end: "~~"})
+		return nil
+	})
 
-			case blackfriday.Emph:
-				wrappers = append(wrappers, &wrapper{begin: "_", end: "_"})
+	return written, !result.Fallback && result.Err == nil, err
+}
 
-			case blackfriday.Strong:
-				wrappers = append(wrappers, &wrapper{begin: "**", end: "**"})
+// SplitStream is like SplitToWriter, but reads the text from r, for pipelines. The whole text is read before
+// splitting, as the titles need the total amount of chunks before the first one is written.
+//
+// Returns the amount of chunks written, and the error of the read or the first write that failed, if any.
+func SplitStream(r io.Reader, w io.Writer, max int, sep, delim string) (int, error) {
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
 
-			case blackfriday.Heading:
-				heading := strings.Repeat("#", parent.Level)
+	n, _, err := SplitToWriter(w, string(text), max, sep, delim)
+	return n, err
+}
 
-				if baseTitle == "" && len(chunks) == 0 {
-					baseTitle = fmt.Sprintf("%s %s", heading, contents)
+// SplitReason tells why a markdown split wasn't possible, if so.
+type SplitReason int
 
-					// give extra 10 characters to the title, just in case the totalComments grow too much
-					titleLen = len(baseTitle) + len(titleSuffixFmt) + 10
+// The reasons why a markdown split may not be possible.
+const (
+	// SplitOK means the markdown split was possible.
+	SplitOK SplitReason = iota
+	// ReasonMaxNotPositive means max isn't positive, so nothing can fit in a chunk.
+	ReasonMaxNotPositive
+	// ReasonSeparatorTooLong means the separator doesn't leave room for anything else in a chunk.
+	ReasonSeparatorTooLong
+	// ReasonFrontMatterTooLong means the front matter can't be kept whole in a chunk.
+	ReasonFrontMatterTooLong
+	// ReasonListsNotSplit means the text has a list, but Options.SplitLists isn't set.
+	ReasonListsNotSplit
+	// ReasonTableTooLong means a row of a table doesn't fit in a chunk along with the header.
+	ReasonTableTooLong
+	// ReasonMarkupTooLong means the markup around some contents (like the fences of a code block,
+	// or the markers of a list item) doesn't leave room for them in a chunk.
+	ReasonMarkupTooLong
+	// ReasonTooManyChunks means there would be more chunks than Options.MaxChunks.
+	ReasonTooManyChunks
+	// ReasonAffixesTooLong means Options.ChunkPrefix and Options.ChunkSuffix don't leave room for anything else in a chunk.
+	ReasonAffixesTooLong
+	// ReasonChunkTooLong means some contents don't fit in a chunk along with its title and markup,
+	// which are only known in full once the chunks are assembled.
+	ReasonChunkTooLong
+	// ReasonRawHTMLTooLong means a <script> or <style> block, which can't be cut, doesn't fit in a chunk.
+	ReasonRawHTMLTooLong
+)
 
-					return blackfriday.GoToNext
-				}
+// String explains the reason.
+func (r SplitReason) String() string {
+	switch r {
+	case SplitOK:
+		return ""
+	case ReasonMaxNotPositive:
+		return "max isn't positive"
+	case ReasonSeparatorTooLong:
+		return "the separator doesn't fit in max"
+	case ReasonFrontMatterTooLong:
+		return "the front matter doesn't fit in max"
+	case ReasonListsNotSplit:
+		return "lists are only split with SplitLists"
+	case ReasonTableTooLong:
+		return "a table row doesn't fit in max along with the header"
+	case ReasonMarkupTooLong:
+		return "the markup doesn't fit in max along with the contents"
+	case ReasonTooManyChunks:
+		return "there are more chunks than MaxChunks"
+	case ReasonAffixesTooLong:
+		return "the chunk prefix and suffix don't fit in max"
+	case ReasonChunkTooLong:
+		return "a chunk doesn't fit in max along with its title and markup"
+	case ReasonRawHTMLTooLong:
+		return "a script or style block doesn't fit in max"
+	}
 
-				wrappers = append(wrappers, &wrapper{begin: heading + " ", end: "\n\n"})
+	return "unknown reason " + strconv.Itoa(int(r))
+}
 
-			case blackfriday.Link:
-				linkData := parent.LinkData
+// ErrTooManyChunks is the error of a split that would produce more chunks than Options.MaxChunks.
+var ErrTooManyChunks = errors.New("too many chunks")
+
+// SplitResult holds the chunks of a split along with some details about how they were made.
+type SplitResult struct {
+	Chunks []string
+	// Fallback tells whether a simple split was performed, as the markdown split wasn't possible
+	Fallback bool
+	// Reason explains why the markdown split wasn't possible, when Fallback is set
+	Reason string
+	// ReasonCode is the reason explained by Reason, SplitOK when Fallback isn't set
+	ReasonCode SplitReason
+	// ByteRanges holds the start and end offsets in the text of the contents of every chunk.
+	// They are exact for simple splits, but only approximate for markdown ones, as their contents are rebuilt.
+	ByteRanges [][2]int
+	// Err is ErrTooManyChunks if there would be more chunks than Options.MaxChunks,
+	// in which case only the first ones are returned.
+	Err error
+}
 
-				var sb strings.Builder
-				sb.WriteString("](")
+// SplitDetailed is like MarkdownSplitOpts, but returns the details of the split along with the chunks.
+func SplitDetailed(text string, max int, sep string, opts Options) SplitResult {
+	var result SplitResult
+	if opts.ChunkPrefix != "" || opts.ChunkSuffix != "" || opts.FirstChunkPrefix != "" || opts.ContinuationPrefix != "" {
+		result = splitWithAffixes(text, max, sep, opts)
+	} else {
+		result = splitChunks(text, max, sep, opts)
+	}
 
-				linkDest, linkTitle := string(linkData.Destination), string(linkData.Title)
-				if linkDest != "" {
-					sb.WriteString(linkDest)
-				}
+	if result.Fallback && opts.OnFallback != nil {
+		opts.OnFallback(result.ReasonCode)
+	}
 
-				if linkTitle != "" {
-					sb.WriteString(fmt.Sprintf(" \"%s\"", linkTitle))
-				}
+	return result
+}
+
+// splitChunks performs the split of SplitDetailed, apart from the prefix and suffix of the chunks.
+func splitChunks(text string, max int, sep string, opts Options) SplitResult {
+	restoreCRLF := opts.OutputLineEnding == CRLF
+	original := text
+	if opts.NormalizeLineEndings {
+		preserve := opts.RestoreCRLF || opts.OutputLineEnding == PreserveLineEnding
+		restoreCRLF = restoreCRLF || preserve && strings.Contains(text, "\r\n")
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+		text = strings.ReplaceAll(text, "\r", "\n")
+	}
+
+	if opts.TabWidth > 0 {
+		text = expandTabs(text, opts.TabWidth)
+	}
+
+	if opts.Normalize {
+		text = opts.NormalizationForm.String(text)
+	}
 
-				sb.WriteString(")")
+	result := splitDetailed(text, max, sep, opts)
 
-				begin, end := "[", sb.String()
-				wrappers = append(wrappers, &wrapper{begin: begin, end: end})
+	if restoreCRLF {
+		// every line ending takes one more character once restored, so the split is repeated with a
+		// max reduced by the excess of the longest chunk until all of them fit
+		for chunksMax := max; ; {
+			excess := 0
+			for _, chunk := range result.Chunks {
+				if e := opts.LengthMode.measure(toCRLF(chunk)) - max; e > excess {
+					excess = e
+				}
+			}
+
+			if excess == 0 || chunksMax-excess <= 0 {
+				break
 			}
 
-			parent = parent.Parent
+			chunksMax -= excess
+			result = splitDetailed(text, chunksMax, sep, opts)
 		}
+	}
 
-		switch node.Type {
-		case blackfriday.Code:
-			begin, end := "```\n", "\n```"
+	if opts.TrimChunks {
+		result = trimChunks(result)
+	}
 
-			lineBreakIdx := strings.Index(contents, "\n")
-			if lineBreakIdx != -1 {
-				prefix := contents[:lineBreakIdx+1]
-				contents = strings.TrimLeft(contents, prefix)
-				begin = "```" + prefix
-			}
+	if opts.NormalizeLineEndings {
+		// the offsets are in the normalized text, which is shorter by every \r\n turned into \n
+		crlfRanges(original, result.ByteRanges)
+	}
 
-			// remove latest linebreak from code
-			contents = strings.TrimRight(contents, "\n")
-			wrappers = append(wrappers, &wrapper{begin: begin, end: end})
+	if restoreCRLF {
+		for i, chunk := range result.Chunks {
+			result.Chunks[i] = toCRLF(chunk)
+		}
+	}
 
-		case blackfriday.HTMLSpan:
-			if isHTMLOpeningTag(contents) {
-				// close automatically, even if tag wasn't closed in original text
-				htmlWrappers = append(htmlWrappers, &wrapper{contents, getHTMLClosingTag(contents)})
-				contents = ""
-			} else {
-				// check if it's closing the last opened tag, if not, it's badly constructed html
-				if len(htmlWrappers) > 0 && contents == htmlWrappers[len(htmlWrappers)-1].end {
-					htmlWrappers = htmlWrappers[:len(htmlWrappers)-1]
-					contents = ""
+	return limitChunks(result, opts)
+}
+
+func splitDetailed(text string, max int, sep string, opts Options) SplitResult {
+	m := opts.LengthMode
+
+	if max <= 0 {
+		return SplitResult{Fallback: true, Reason: ReasonMaxNotPositive.String(), ReasonCode: ReasonMaxNotPositive}
+	}
+
+	if strings.TrimSpace(text) == "" {
+		return SplitResult{Chunks: []string{}, ByteRanges: [][2]int{}}
+	}
+
+	// If we're under the limit then no need to split, unless sections must be kept apart or have limits of their own.
+	if m.measure(text) <= max && opts.SplitAtHeadings == 0 && len(opts.MaxByType) == 0 {
+		return SplitResult{Chunks: []string{text}, ByteRanges: [][2]int{{0, len(text)}}}
+	}
+
+	fallback := func(reason SplitReason) SplitResult {
+		chunks := simpleSplit(text, max, sep, opts)
+
+		marker := ""
+		if opts.AnnotateFallback {
+			marker, chunks = fallbackMarker(text, max, sep, opts, chunks)
+		}
+
+		ranges := simpleSplitRanges(chunks, sep)
+		if marker != "" {
+			chunks[0] = marker + chunks[0]
+		}
+
+		return SplitResult{
+			Chunks:     chunks,
+			Fallback:   true,
+			Reason:     reason.String(),
+			ReasonCode: reason,
+			ByteRanges: ranges,
+		}
+	}
+
+	frontMatter, body := "", text
+	if opts.PreserveFrontMatter {
+		frontMatter, body = splitFrontMatter(text)
+	}
+
+	if m.measure(frontMatter) > max {
+		// the front matter can't be kept intact, so just perform a simple text split
+		return fallback(ReasonFrontMatterTooLong)
+	}
+
+	// the front matter goes along with the first chunk emitted, or before it if they don't fit together
+	streamed := false
+	if emit := opts.emit; emit != nil {
+		shift := 0
+		opts.emit = func(index, total int, chunk string) error {
+			streamed = true
+
+			if index == 0 && frontMatter != "" {
+				if m.measure(frontMatter)+m.measure(chunk) <= max {
+					chunk = frontMatter + chunk
+				} else {
+					shift = 1
+					if err := emit(0, total+shift, frontMatter); err != nil {
+						return err
+					}
 				}
 			}
+
+			return emit(index+shift, total+shift, chunk)
 		}
+	}
 
-		// add pending htmlWrappers to current wrappers, in case there are any
-		wrappers = append(wrappers, htmlWrappers...)
+	chunks, reason := markdownSplit(body, max, sep, opts)
+	if streamed {
+		return SplitResult{}
+	}
+	if reason == ReasonTooManyChunks {
+		return SplitResult{Chunks: chunks, ByteRanges: markdownSplitRanges(text, len(text)-len(body), chunks), Err: ErrTooManyChunks}
+	}
+	if reason != SplitOK {
+		return fallback(reason)
+	}
 
-		wLen := 0
-		for _, w := range wrappers {
-			wLen += len(w.begin) + len(w.end)
+	if opts.Balanced {
+		chunks = balance(body, max, sep, opts, chunks)
+	} else if opts.Rebalance {
+		chunks = rebalance(body, max, sep, opts, chunks)
+	}
+
+	if opts.PreserveEdgeWhitespace && !opts.Lossless {
+		chunks = keepEdgeWhitespace(body, chunks, max, m)
+	}
+
+	ranges := markdownSplitRanges(text, len(text)-len(body), chunks)
+
+	if frontMatter != "" {
+		if len(chunks) == 0 {
+			// nothing was left after the front matter, like when it's followed only by html tags
+			chunks = []string{frontMatter}
+			ranges = [][2]int{{0, len(frontMatter)}}
+		} else if m.measure(frontMatter)+m.measure(chunks[0]) <= max {
+			chunks[0] = frontMatter + chunks[0]
+			ranges[0][0] = 0
+		} else {
+			chunks = append([]string{frontMatter}, chunks...)
+			ranges = append([][2]int{{0, len(frontMatter)}}, ranges...)
 		}
+	}
+
+	return SplitResult{Chunks: chunks, ByteRanges: ranges}
+}
 
-		sepLen := len(sep)
+// fallbackMarker returns the FallbackMarker of opts, along with the chunks of a simple split of the text that leave
+// room for it at the beginning of the first one, splitting it again if needed. If it doesn't fit, no marker is
+// returned along with the chunks as they were.
+func fallbackMarker(text string, max int, sep string, opts Options, chunks []string) (string, []string) {
+	marker := opts.FallbackMarker
+	if marker == "" {
+		marker = DefaultFallbackMarker
+	}
 
-		// sum the length of the extra added contents, apart from the text contents
-		extraLen := wLen + titleLen + sepLen
+	marked := simpleSplit(text, max-opts.LengthMode.measure(marker), sep, opts)
+	if len(marked) == 0 {
+		return "", chunks
+	}
 
-		if extraLen >= max {
-			// we don't have enough space to do this, so just perform a simple text split
-			canSplit = false
-			return blackfriday.Terminate
+	if len(marked) > 1 {
+		rest := text[len(marked[0])-len(sep):]
+		marked = append(marked[:1], simpleSplit(rest, max, sep, opts)...)
+	}
+
+	return marker, marked
+}
+
+// toCRLF turns the line endings of s into \r\n, leaving the ones that already were as they are.
+func toCRLF(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+}
+
+// keepEdgeWhitespace puts the blank lines the text begins with and the whitespace it ends with, which the
+// markdown split leaves out, back at the beginning of the first chunk and the end of the last one, as long as
+// they fit in them. The whitespace the last chunk already ends with is replaced, so it isn't doubled.
+func keepEdgeWhitespace(text string, chunks []string, max int, m LengthMode) []string {
+	if len(chunks) == 0 {
+		return chunks
+	}
+
+	// the indentation of the first line is markup, like the one of a code block, so only whole lines are taken
+	lead := text[:len(text)-len(strings.TrimLeft(text, " \t\n"))]
+	lead = lead[:strings.LastIndex(lead, "\n")+1]
+	trail := text[len(strings.TrimRightFunc(text, unicode.IsSpace)):]
+
+	if first := lead + chunks[0]; m.measure(first) <= max {
+		chunks[0] = first
+	}
+
+	last := len(chunks) - 1
+	if chunk := strings.TrimRightFunc(chunks[last], unicode.IsSpace) + trail; m.measure(chunk) <= max {
+		chunks[last] = chunk
+	}
+
+	return chunks
+}
+
+// trimChunks removes the leading and trailing whitespace of every chunk of the result,
+// dropping the ones left empty.
+func trimChunks(result SplitResult) SplitResult {
+	chunks := result.Chunks[:0]
+	ranges := result.ByteRanges[:0]
+
+	for i, chunk := range result.Chunks {
+		r := result.ByteRanges[i]
+
+		trimmed := strings.TrimLeftFunc(chunk, unicode.IsSpace)
+		r[0] += len(chunk) - len(trimmed)
+		chunk = trimmed
+
+		trimmed = strings.TrimRightFunc(chunk, unicode.IsSpace)
+		r[1] -= len(chunk) - len(trimmed)
+		chunk = trimmed
+
+		if chunk == "" {
+			continue
 		}
 
-		chunkLen := max - extraLen
-		chunks = append(chunks, buildChunks(contents, chunkLen, wrappers)...)
+		if r[0] > r[1] {
+			r[0] = r[1]
+		}
 
-		return blackfriday.GoToNext
-	})
+		chunks = append(chunks, chunk)
+		ranges = append(ranges, r)
+	}
+
+	result.Chunks, result.ByteRanges = chunks, ranges
 
-	if !canSplit {
-		return SimpleSplit(text, max, sep), false
+	return result
+}
+
+// limitChunks keeps only the first opts.MaxChunks chunks of the result, if there are more.
+func limitChunks(result SplitResult, opts Options) SplitResult {
+	if opts.MaxChunks > 0 && len(result.Chunks) > opts.MaxChunks {
+		result.Chunks = result.Chunks[:opts.MaxChunks]
+		result.ByteRanges = result.ByteRanges[:opts.MaxChunks]
+		result.Err = ErrTooManyChunks
 	}
 
-	return chunksAsStr(chunks, max, baseTitle, titleSuffixFmt), true
+	return result
 }
 
-// SimpleSplit performs a simple split based on max length and a separator string.
-func SimpleSplit(text string, max int, sep string) []string {
-	// If we're under the limit then no need to split.
-	if len(text) <= max {
-		return []string{text}
+// markdownSplit performs the markdown split of the text.
+// Returns the reason why it's not possible to do it, if so.
+func markdownSplit(text string, max int, sep string, opts Options) ([]string, SplitReason) {
+	m := opts.LengthMode
+
+	// If we're under the limit then no need to split, unless sections must be kept apart or have limits of their own.
+	if m.measure(text) <= max && opts.SplitAtHeadings == 0 && len(opts.MaxByType) == 0 {
+		return []string{text}, SplitOK
 	}
 
 	// If we can't fit the separator string in then this doesn't make sense.
-	if max <= len(sep) {
-		return nil
+	if max <= m.measure(sep) {
+		return nil, ReasonSeparatorTooLong
 	}
 
-	var chunks []string
+	var chunks []*chunk
+	baseTitle := ""
+	titleLen := 0
+
+	// budget is the max of the node being walked, which may be smaller than max with MaxByType
+	budget := max
+	// pieceMax is the max of the output chunks the contents of the node go in, or 0 if it's max.
+	// It leaves room for the separator, as the chunks are assembled without it.
+	pieceMax := func() int {
+		if budget < max {
+			return budget - m.measure(sep)
+		}
 
-	maxSize := max - len(sep)
-	numChunks := int(math.Ceil(float64(len(text)) / float64(maxSize)))
+		return 0
+	}
 
-	for i := 0; i < numChunks; i++ {
-		upTo := min(len(text), (i+1)*maxSize)
-		portion := text[i*maxSize : upTo]
-		if i < numChunks-1 {
-			portion += sep
+	// the chain of the most recent headings, from the top level one, to build breadcrumb titles
+	var headings []*mdNode
+	breadcrumb := ""
+	// section is the number of the current top-level section, which only changes when the titles are numbered per section
+	section := 0
+	titleSuffixFmt := " (%d/%d)"
+	// titleEnd goes after the title, apart from the contents of the chunk
+	titleEnd := "\n\n"
+
+	// renderTitle writes the title of a chunk, numbered with its index and the total
+	renderTitle := func(title string, index, total int) string {
+		if opts.TitleRenderer != nil {
+			return opts.TitleRenderer(title, index, total) + titleEnd
 		}
-		chunks = append(chunks, portion)
+
+		return title + fmt.Sprintf(titleSuffixFmt, index, total) + titleEnd
 	}
 
-	return chunks
-}
+	// titleRoom is the room to leave for the title in every chunk, with an extra 10 characters just in case
+	// the total grows too much
+	titleRoom := func(title string) int {
+		if opts.TitleRenderer != nil {
+			return m.measure(renderTitle(title, 1, 1)) + 10
+		}
+
+		return m.measure(title) + m.measure(titleSuffixFmt) + m.measure(titleEnd) + 10
+	}
+	// failure is the reason why the split isn't possible, once found
+	failure := SplitOK
+
+	// fail stops walking the document, as it can't be split for the given reason
+	fail := func(reason SplitReason) walkStatus {
+		failure = reason
+		return terminate
+	}
+
+	var htmlWrappers []*wrapper
+
+	// mathExprs holds the math expressions taken out of the text before parsing it, if enabled
+	var mathExprs *mathExpressions
+
+	// handleHTMLTag tracks the opening and closing tags in htmlWrappers, so they get reopened in every chunk.
+	// Returns false if it's a badly constructed closing tag, which must be treated as text.
+	handleHTMLTag := func(tag string) bool {
+		if isHTMLOpeningTag(tag) {
+			// close automatically, even if tag wasn't closed in original text
+			htmlWrappers = append(htmlWrappers, &wrapper{begin: tag, end: getHTMLClosingTag(tag)})
+			return true
+		}
+
+		// check if it's closing the last opened tag, if not, it's badly constructed html.
+		// Tags are matched by name, as the opening ones may have attributes, and their case doesn't matter.
+		if len(htmlWrappers) > 0 && strings.HasPrefix(tag, "</") &&
+			getHTMLTagName(tag) == getHTMLTagName(htmlWrappers[len(htmlWrappers)-1].end) {
+			htmlWrappers = htmlWrappers[:len(htmlWrappers)-1]
+			return true
+		}
 
-func isHTMLOpeningTag(tag string) bool {
-	if strings.HasPrefix(tag, "</") {
 		return false
 	}
-	return true
-}
 
-func getHTMLClosingTag(open string) string {
-	return strings.Replace(open, "<", "</", 1)
-}
+	// breakNext makes the next chunk added begin a new output chunk
+	breakNext := false
+	// jointNext is the joint of the next chunk added
+	jointNext := ""
 
-func buildChunks(contents string, chunkLen int, wrappers []*wrapper) []*chunk {
-	var result []*chunk
+	// extraLen sums the length of the extra added contents to a chunk with the given wrappers,
+	// apart from the text contents
+	extraLen := func(wrappers []*wrapper) int {
+		wLen := 0
+		for _, w := range wrappers {
+			wLen += m.measure(w.begin) + m.measure(w.end)
+		}
+		wLen += m.measure(definitionsSuffix(wrapperDefinitions(nil, wrappers)))
 
-	for contents != "" {
-		c := &chunk{}
-		c.wrappers = wrappers
+		sepLen := m.measure(sep)
 
-		if len(contents) <= chunkLen {
-			c.content = contents
-			contents = ""
-		} else {
-			c.content = contents[0:chunkLen]
-			contents = contents[chunkLen:]
+		return wLen + titleLen + sepLen
+	}
+
+	// withHTMLWrappers adds the pending htmlWrappers to the wrappers, in case there are any. They are kept
+	// from the outermost to the innermost, so they go in reverse to mirror the order they were opened in.
+	withHTMLWrappers := func(wrappers []*wrapper) []*wrapper {
+		wrappers = wrappers[:len(wrappers):len(wrappers)]
+		for i := len(htmlWrappers) - 1; i >= 0; i-- {
+			wrappers = append(wrappers, htmlWrappers[i])
 		}
 
-		result = append(result, c)
+		return wrappers
 	}
 
-	return result
-}
+	// addChunks splits the contents so every chunk fits in max along with its wrappers, the title and the separator.
+	// Returns false if there's not enough space to do it.
+	addChunks := func(contents string, wrappers []*wrapper) bool {
+		wrappers = withHTMLWrappers(wrappers)
 
-func chunksAsStr(chunks []*chunk, max int, baseTitle, titleSuffixFmt string) []string {
-	// generate a random ID to find within the text, so we can make replacements later
-	// when the necessary data is known (the total amount of comments)
-	textAnchor := genTextAnchor()
+		start := len(chunks)
+		var ok bool
+		if chunks, ok = buildChunks(chunks, contents, budget-extraLen(wrappers), wrappers, opts); !ok {
+			// we don't have enough space to do this, so just perform a simple text split
+			chunks = chunks[:start]
+			return false
+		}
 
-	var result []string
-	curChunk := 1
+		built := chunks[start:]
+		if len(built) > 0 && breakNext {
+			built[0].newChunk = true
+			breakNext = false
+		}
+		if len(built) > 0 {
+			built[0].joint = jointNext
+			jointNext = ""
+		}
+		for _, c := range built {
+			c.title = breadcrumb
+			c.section = section
+			c.max = pieceMax()
+		}
 
-	for _, cm := range chunks {
-		cmStr := ""
+		return true
+	}
 
-		for _, w := range cm.wrappers {
-			cmStr = w.begin + cmStr
+	// addWhole adds the contents in a single chunk, as they can't be cut.
+	// Returns false if they don't fit in one.
+	addWhole := func(contents string, wrappers []*wrapper) bool {
+		if m.measure(contents) > budget-extraLen(append(wrappers[:len(wrappers):len(wrappers)], htmlWrappers...)) {
+			return false
 		}
 
-		cmStr = cmStr + cm.content
+		return addChunks(contents, wrappers)
+	}
 
-		for _, w := range cm.wrappers {
-			cmStr = cmStr + w.end
+	// addURL adds the contents holding a url in a single chunk, like addWhole. With KeepURLsWhole, they
+	// go in a chunk of their own if they don't fit in one, even if it's longer than max.
+	addURL := func(contents string, wrappers []*wrapper) bool {
+		if addWhole(contents, wrappers) {
+			return true
 		}
 
-		if len(result) > 0 {
-			prev := result[len(result)-1]
+		if !opts.KeepURLsWhole {
+			return false
+		}
 
-			if len(prev)+len(cmStr) <= max {
-				result[len(result)-1] += cmStr
-				continue
-			}
+		chunks = append(chunks, &chunk{
+			content: contents, wrappers: withHTMLWrappers(wrappers), newChunk: true, title: breadcrumb, section: section,
+			oversized: true,
+		})
+		breakNext = true
+
+		return true
+	}
+
+	// addLines splits the contents by lines first, so they are only cut when they don't fit in a chunk
+	// on their own. Every line brings its own leading line break and prefix, so the wrappers (like the
+	// fences of a code block) can go around any of them.
+	addLines := func(contents, prefix string, wrappers []*wrapper) bool {
+		// the space left for a line, apart from its line break and prefix
+		lineLen := budget - extraLen(append(wrappers[:len(wrappers):len(wrappers)], htmlWrappers...)) - 1 - m.measure(prefix)
+		if lineLen < 1 {
+			return false
 		}
 
-		if baseTitle != "" {
-			title := baseTitle + fmt.Sprintf(titleSuffixFmt, curChunk, textAnchor)
-			cmStr = title + cmStr
+		for _, line := range strings.Split(contents, "\n") {
+			for first := true; first || line != ""; first = false {
+				upTo := 0
+				if line != "" {
+					upTo = cutOutsideCodeTokens(line, m.cut(line, lineLen))
+					if opts.KeepCombiningMarks {
+						upTo = cutOutsideCombiningMarks(line, upTo)
+					}
+				}
+
+				if !addChunks("\n"+prefix+line[:upTo], wrappers) {
+					return false
+				}
+
+				line = line[upTo:]
+			}
 		}
 
-		result = append(result, cmStr)
-		curChunk += 1
+		return true
 	}
 
-	totalStr := strconv.Itoa(len(result))
+	// textLinksRe matches the links the parser leaves as text
+	textLinksRe := unresolvedReferenceRe
+	if opts.WikiLinks {
+		textLinksRe = unresolvedReferenceOrWikiLinkRe
+	}
 
-	for i := 0; i < len(result); i++ {
-		result[i] = strings.Replace(result[i], textAnchor, totalStr, 1)
+	// addPlainText adds the contents of a text, keeping the reference-style links left as text (as they have no
+	// definition) and the wiki-style ones in a single chunk, so their brackets aren't cut apart. The ones that
+	// don't fit in one are cut along with the rest of the text.
+	addPlainText := func(contents string, wrappers []*wrapper) bool {
+		// all the links left as text have brackets, so most texts can be added right away
+		if !strings.Contains(contents, "[") {
+			return addChunks(contents, wrappers)
+		}
+
+		pending := ""
+		for i, part := range splitTextLinks(contents, textLinksRe) {
+			if i%2 == 1 && m.measure(part) <= budget-extraLen(withHTMLWrappers(wrappers)) {
+				if !addChunks(pending, wrappers) || !addChunks(part, wrappers) {
+					return false
+				}
+
+				pending = ""
+				continue
+			}
+
+			pending += part
+		}
+
+		return addChunks(pending, wrappers)
 	}
 
-	return result
-}
+	// addText adds the contents of a text, keeping its math expressions whole: the display ones in a
+	// block of their own, split by lines, and the inline ones in a single chunk.
+	addText := func(contents string, wrappers []*wrapper) bool {
+		if !mathExprs.in(contents) {
+			return addPlainText(contents, wrappers)
+		}
+
+		for i, part := range mathExprs.split(contents) {
+			var ok bool
+
+			switch {
+			case i%2 == 0:
+				ok = addPlainText(part, wrappers)
+			case isDisplayMath(part):
+				block := append(wrappers[:len(wrappers):len(wrappers)], &wrapper{begin: "$$", end: "\n$$\n"})
+				ok = addLines(strings.Trim(part[2:len(part)-2], "\n"), "", block)
+			default:
+				ok = addWhole(part, wrappers)
+			}
+
+			if !ok {
+				return false
+			}
+		}
 
-func min(a, b int) int {
-	if a < b {
-		return a
+		return true
 	}
-	return b
-}
 
-func genTextAnchor() string {
-	const charset = "abcdefghijklmnopqrstuvwxyz" +
-		"ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	// addTable splits the table by rows, repeating the header in every chunk
+	// so each of them is a valid table on its own.
+	addTable := func(node *mdNode) bool {
+		var header strings.Builder
+		var rows []string
+
+		node.walk(func(row *mdNode, entering bool) walkStatus {
+			if row.typ != tableRowNode || !entering {
+				return goToNext
+			}
 
-	seededRand := rand.New(rand.NewSource(time.Now().UnixNano()))
+			if row.parent.typ == tableHeadNode {
+				header.WriteString(mathExprs.restore(renderTableRow(row)))
+				header.WriteString(renderTableDelimiterRow(row))
+			} else {
+				rows = append(rows, mathExprs.restore(renderTableRow(row)))
+			}
 
-	b := make([]byte, 32)
-	for i := range b {
-		b[i] = charset[seededRand.Intn(len(charset))]
+			return skipChildren
+		})
+
+		tableWrapper := &wrapper{begin: header.String(), end: ""}
+
+		for _, row := range rows {
+			if m.measure(tableWrapper.begin)+m.measure(row)+titleLen+m.measure(sep) > budget {
+				// a row can't be split without breaking the table
+				return false
+			}
+
+			if !addChunks(row, []*wrapper{tableWrapper}) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	// attachFootnote appends the marker of a footnote reference to the last chunk, as it has no
+	// contents on its own and it must stay next to the text it annotates. The footnote definition
+	// is carried along to the chunk.
+	attachFootnote := func(node *mdNode) bool {
+		label := string(node.destination)
+		marker := "[^" + label + "]"
+		note := &wrapper{definition: fmt.Sprintf("[^%s]: %s", label, mathExprs.restore(strings.TrimSpace(string(node.title))))}
+
+		if len(chunks) == 0 {
+			return addChunks(marker, []*wrapper{note})
+		}
+
+		last := chunks[len(chunks)-1]
+		wrappers := append(last.wrappers[:len(last.wrappers):len(last.wrappers)], note)
+
+		chunkLen := budget - extraLen(wrappers)
+		if chunkLen <= m.measure(marker) {
+			return false
+		}
+
+		if m.measure(last.content+marker) <= chunkLen {
+			last.content += marker
+			last.wrappers = wrappers
+			return true
+		}
+
+		// move the last word along with the marker to a new chunk
+		idx := strings.LastIndexAny(last.content, " \n") + 1
+		if m.measure(last.content[idx:]+marker) > chunkLen {
+			idx = len(last.content)
+		}
+
+		word := last.content[idx:]
+		last.content = last.content[:idx]
+		chunks = append(chunks, &chunk{content: word + marker, wrappers: wrappers, max: last.max, title: last.title, section: last.section})
+
+		return true
+	}
+
+	// paragraphStart is the index of the first chunk of the current paragraph
+	paragraphStart := 0
+
+	// keepWhole makes the chunks of a paragraph go together in an output chunk, as long as they fit in one
+	// on their own, along with the title
+	keepWhole := func(paragraph []*chunk) {
+		noTitle := func(string, int, int) string { return "" }
+
+		whole, _, _ := assembleChunks(paragraph, max-m.measure(sep)-titleLen, "", noTitle, sameTotal(1), 0, m)
+		if len(whole) == 1 {
+			paragraph[0].wholeLen = m.measure(whole[0])
+		}
+	}
+
+	var listStarts map[*mdNode]int
+
+	// itemWrappers keeps the wrapper of every list item already seen, so all the contents of an item share it
+	itemWrappers := map[*mdNode]*wrapper{}
+	// checkboxes keeps the text nodes starting with the checkbox of a task list item, which is moved to its marker
+	checkboxes := map[*mdNode]string{}
+
+	itemWrapper := func(item *mdNode) *wrapper {
+		if w, ok := itemWrappers[item]; ok {
+			return w
+		}
+
+		marker := itemIndentation(item, listStarts) + itemMarker(item, listStarts)
+		if text, checkbox := itemCheckbox(item); text != nil {
+			marker += checkbox
+			checkboxes[text] = checkbox
+		}
+
+		w := &wrapper{begin: marker, end: "\n"}
+		itemWrappers[item] = w
+
+		return w
+	}
+
+	// quoteWrappers keeps the wrapper of every blockquote already seen, so all its contents share it
+	quoteWrappers := map[*mdNode]*wrapper{}
+
+	quoteWrapper := func(quote *mdNode) *wrapper {
+		if w, ok := quoteWrappers[quote]; ok {
+			return w
+		}
+
+		// the blank line keeps what comes next out of the quote, while the blocks after a nested one
+		// bring their own blank line
+		w := &wrapper{begin: "> ", end: "\n\n"}
+		if quote.parent.typ == blockQuoteNode {
+			w.end = ""
+		}
+		quoteWrappers[quote] = w
+
+		return w
+	}
+
+	var refs *referenceLinks
+	if !opts.InlineReferenceLinks {
+		refs = findReferenceLinks(text)
+	}
+
+	// inlineWrappers keeps the wrapper of every inline node already seen (like emphasis or links), so all
+	// the text nodes inside of the same one share it, and it isn't closed and reopened between them.
+	// It also makes the usage of a reference-style link be matched only once.
+	inlineWrappers := map[*mdNode]*wrapper{}
+
+	inlineNodeWrapper := func(node *mdNode) *wrapper {
+		if w, ok := inlineWrappers[node]; ok {
+			return w
+		}
+
+		w := inlineWrapper(node)
+		if node.typ == linkNode && refs != nil {
+			if usage, ok := refs.match(string(node.destination)); ok {
+				w = &wrapper{begin: "[", end: usage.suffix, definition: usage.definition}
+			}
+		}
+
+		inlineWrappers[node] = w
+
+		return w
+	}
+
+	var autolinks map[string]string
+	if opts.PreserveAutolinks {
+		autolinks = findAutolinks(text)
+	}
+
+	// the fences of the code blocks, in order, so they are reused in every chunk
+	fences := findCodeFences(text)
+
+	if opts.Math {
+		text, mathExprs = extractMath(text)
+	}
+
+	extensions := blackfriday.Strikethrough | opts.Extensions
+	if extensions&blackfriday.Footnotes != 0 && hasFootnoteCycle(text) {
+		// the footnotes are left as text, as the parser would never end with them
+		extensions &^= blackfriday.Footnotes
+	}
+
+	var md parser = blackfridayParser{}
+	if opts.parser != nil {
+		md = opts.parser
+	}
+
+	rootNode := md.parse(text, extensions)
+
+	// the numbers the ordered lists begin with
+	listStarts = findListStarts(text, rootNode)
+
+	// wholeLinks are the links added whole with KeepURLsWhole, as they didn't leave room for their text
+	wholeLinks := map[*mdNode]bool{}
+
+	// the maxes of the types of nodes, by the types of the tree
+	maxByType := nodeTypeMaxes(opts.MaxByType)
+
+	// skippedNodes are the nodes already added some other way, like the summary of a collapsible section
+	skippedNodes := map[*mdNode]bool{}
+
+	// cursor is the position in the text right after the last literal seen, to find what was skipped
+	// by the parser between literals, like blank lines or the backslash of an escape
+	cursor := 0
+
+	rootNode.walk(func(node *mdNode, entering bool) walkStatus {
+		budget = nodeMax(node, max, maxByType)
+
+		if skippedNodes[node] {
+			return skipChildren
+		}
+
+		// the blocks of a blockquote or a list item after the first one are kept in it by the prefix of their
+		// lines, and apart from the previous one by a blank line. When one begins a chunk, the blockquote or
+		// the item is opened again instead.
+		if entering && hasBlockJoint(node) && (node.typ == codeBlockNode || !opts.Lossless && !opts.PreserveBlankLines) {
+			jointNext = blockJoint(node, listStarts)
+		}
+
+		// a list only ends with the line break of its last item, so the paragraph or the heading after it
+		// needs one more to be kept out of the item
+		if entering && (node.typ == paragraphNode || node.typ == headingNode) &&
+			node.parent.typ == documentNode && node.prev != nil && node.prev.typ == listNode &&
+			!opts.Lossless && !opts.PreserveBlankLines {
+			jointNext = "\n"
+		}
+
+		switch node.typ {
+		case itemNode:
+			// the items of a list in a blockquote begin a quoted line of their own
+			if entering && (node.prev != nil || node.parent.prev != nil && node.parent.parent.typ == itemNode) {
+				if prefix := quotePrefixOf(node); prefix != "" {
+					jointNext = prefix
+				}
+			}
+
+		case listNode:
+			// footnote definitions are carried along with their references
+			if node.footnotesList {
+				return skipChildren
+			}
+
+			if !opts.SplitLists {
+				return fail(ReasonListsNotSplit)
+			}
+
+		case linkNode:
+			if node.noteID != 0 && entering && !attachFootnote(node) {
+				return fail(ReasonMarkupTooLong)
+			}
+
+		case tableNode:
+			if !addTable(node) {
+				return fail(ReasonTableTooLong)
+			}
+
+			return skipChildren
+
+		case headingNode:
+			if entering && node.level <= opts.SplitAtHeadings {
+				breakNext = true
+			}
+
+			if entering && opts.BreadcrumbTitles {
+				for len(headings) > 0 && headings[len(headings)-1].level >= node.level {
+					headings = headings[:len(headings)-1]
+				}
+				if len(headings) == 0 && opts.NumberTitlesPerSection {
+					section++
+				}
+				headings = append(headings, node)
+
+				breadcrumb = mathExprs.restore(breadcrumbTitle(headings))
+				titleLen = titleRoom(breadcrumb)
+				// every section begins a new chunk, so its breadcrumb is accurate for all its contents
+				breakNext = true
+			}
+
+		case blockQuoteNode:
+			// a quote must begin a line of its own, apart from what comes before it
+			if entering && node.parent != nil && node.parent.typ == documentNode && len(chunks) > 0 &&
+				!addChunks("\n\n", nil) {
+				return fail(ReasonMarkupTooLong)
+			}
+
+		case paragraphNode:
+			if entering {
+				paragraphStart = len(chunks)
+			} else {
+				jointNext = ""
+
+				for i := paragraphStart + 1; i < len(chunks); i++ {
+					chunks[i].midParagraph = true
+				}
+
+				if opts.KeepParagraphsWhole && len(chunks) > paragraphStart {
+					keepWhole(chunks[paragraphStart:])
+				}
+			}
+
+		case horizontalRuleNode:
+			// surrounded by blank lines, so it's never mistaken for a setext heading underline
+			if !addChunks("\n\n---\n\n", nil) {
+				return fail(ReasonMarkupTooLong)
+			}
+
+			breakNext = opts.PreferRuleBreaks
+			return goToNext
+		}
+
+		if node.literal == nil && node.typ != hardbreakNode {
+			return goToNext
+		}
+
+		// gap is what the parser skipped in the text since the previous literal, like the blank lines between blocks
+		gap := ""
+		escaped := false
+		if node.literal != nil {
+			idx := strings.Index(text[cursor:], string(node.literal))
+			// blackfriday gives escaped characters a text of their own, without the backslash
+			if node.typ == textNode && isEscapable(string(node.literal)) {
+				if escIdx := strings.Index(text[cursor:], `\`+string(node.literal)); escIdx != -1 && escIdx <= idx {
+					idx, escaped = escIdx+1, true
+				}
+			}
+
+			if idx != -1 {
+				gap = text[cursor : cursor+idx]
+				cursor += idx + len(node.literal)
+			}
+		}
+
+		contents := string(node.literal)
+		if node.typ != textNode {
+			// math expressions are only split apart from text, anywhere else they are just text too
+			contents = mathExprs.restore(contents)
+		}
+		var wrappers []*wrapper
+		inItem := false
+		autolink := ""
+		// the link around the node, and where its wrapper is among the wrappers
+		var link *mdNode
+		linkIdx := 0
+		// quotePrefix goes at the beginning of every line inside blockquotes, one "> " for each of them
+		quotePrefix := ""
+		// itemPrefix goes at the beginning of every line of the paragraphs of a list item after the first one
+		itemPrefix := ""
+		// child is the node in the branch of the parent being looked at, just under it
+		child := node
+
+		parent := node.parent
+		for parent != nil {
+			switch parent.typ {
+			case itemNode:
+				// nested items are indented on their own, so only the innermost one is needed
+				if !inItem {
+					wrappers = append(wrappers, itemWrapper(parent))
+					inItem = true
+
+					if (child.typ == paragraphNode || child.typ == codeBlockNode) && child.prev != nil &&
+						!opts.Lossless && !opts.PreserveBlankLines {
+						itemPrefix = itemContinuationIndentation(parent, listStarts)
+					}
+				}
+
+			case blockQuoteNode:
+				wrappers = append(wrappers, quoteWrapper(parent))
+				quotePrefix += "> "
+
+			case delNode, emphNode, strongNode, imageNode:
+				wrappers = append(wrappers, inlineNodeWrapper(parent))
+
+			case linkNode:
+				if text, ok := autolinkText(parent, autolinks); ok {
+					autolink = text
+					break
+				}
+
+				link, linkIdx = parent, len(wrappers)
+				wrappers = append(wrappers, inlineNodeWrapper(parent))
+
+			case headingNode:
+				// the heading is already the title of the chunks of its section
+				if opts.BreadcrumbTitles {
+					return goToNext
+				}
+
+				heading := strings.Repeat("#", parent.level)
+
+				underline := ""
+				if opts.PreserveSetextHeadings {
+					underline = setextUnderline(text, contents)
+				}
+
+				if baseTitle == "" && len(chunks) == 0 && !opts.untitled {
+					baseTitle = fmt.Sprintf("%s %s", heading, mathExprs.restore(contents))
+					if underline != "" {
+						// the suffix goes in the same line as the title, so the underline comes after it
+						baseTitle = mathExprs.restore(contents)
+						titleEnd = "\n" + underline + "\n\n"
+					}
+
+					titleLen = titleRoom(baseTitle)
+
+					return goToNext
+				}
+
+				if underline != "" {
+					wrappers = append(wrappers, &wrapper{begin: "", end: "\n" + underline + "\n\n"})
+				} else {
+					wrappers = append(wrappers, &wrapper{begin: heading + " ", end: "\n\n"})
+				}
+			}
+
+			child, parent = parent, parent.parent
+		}
+
+		contents = strings.TrimPrefix(contents, checkboxes[node])
+		linePrefix := quotePrefix + itemPrefix
+
+		if autolink != "" {
+			// the url can't be cut
+			if !addURL(autolink, wrappers) {
+				return fail(ReasonMarkupTooLong)
+			}
+
+			return goToNext
+		}
+
+		if link != nil && opts.KeepURLsWhole {
+			// the whole link was added along with its first text
+			if wholeLinks[link] {
+				return goToNext
+			}
+
+			// with no room left for its text, the link is added whole instead of failing
+			if extraLen(withHTMLWrappers(wrappers[linkIdx:])) >= budget {
+				wholeLinks[link] = true
+
+				w := inlineNodeWrapper(link)
+				if !addURL(w.begin+renderInline(link)+w.end, wrappers[linkIdx+1:]) {
+					return fail(ReasonMarkupTooLong)
+				}
+
+				return goToNext
+			}
+		}
+
+		switch node.typ {
+		case codeNode:
+			// a code span is kept inline, its backticks outnumbering any run of them in its contents
+			wrappers = append(wrappers, codeSpanWrapper(contents))
+
+		case codeBlockNode:
+			// indented code blocks are fenced too, so every chunk gets a self-contained block
+			fence := "```"
+			if node.fenced && len(fences) > 0 {
+				fence, fences = fences[0], fences[1:]
+			}
+			fence = fenceFor(fence, contents)
+
+			// the whole info string (like go title="main.go" {1,3}) goes in the fence of every chunk
+			info := string(node.info)
+
+			// remove latest linebreak from code
+			code := strings.TrimRight(contents, "\n")
+			if code == "" {
+				// an empty block has no lines to put between the fences
+				if !addWhole(fence+info+"\n"+fence+"\n", wrappers) {
+					return fail(ReasonMarkupTooLong)
+				}
+
+				return goToNext
+			}
+
+			// when the block begins a chunk, the fence goes right after the marker of the item the block is in, so
+			// it's padded up to the indentation of the lines, otherwise the code would be indented by the difference.
+			// The joint leaves room for the padding when the block doesn't begin a chunk.
+			pad := ""
+			if node.parent.typ == itemNode {
+				marker := itemIndentation(node.parent, listStarts) + itemMarker(node.parent, listStarts)
+				pad = strings.Repeat(" ", len(itemContinuationIndentation(node.parent, listStarts))-len(marker))
+				jointNext = strings.TrimSuffix(jointNext, pad)
+			}
+
+			// the fences go inside of the blockquotes and list items, like every line of the code
+			wrappers = append([]*wrapper{{begin: pad + fence + info, end: "\n" + linePrefix + fence + "\n"}}, wrappers...)
+
+			if !addLines(code, linePrefix, wrappers) {
+				return fail(ReasonMarkupTooLong)
+			}
+
+			return goToNext
+
+		case hardbreakNode:
+			// two trailing spaces work with any set of extensions, unlike the backslash.
+			// In lossless mode the original break is kept along with the next text instead.
+			contents = "  \n" + linePrefix
+			if opts.Lossless {
+				contents = ""
+			}
+
+		case textNode:
+			// escapes are kept, so the characters aren't taken as markup again
+			if escaped {
+				gap = strings.TrimSuffix(gap, `\`)
+				contents = `\` + contents
+			}
+
+			// whitespace (like the blank lines between paragraphs) has no markup to rebuild it,
+			// so it's kept as it was
+			if opts.Lossless && losslessGapRe.MatchString(gap) {
+				contents = gap + contents
+			} else if opts.PreserveBlankLines && blankLinesGapRe.MatchString(gap) {
+				contents = gap + contents
+			}
+
+			// the backslash is useless without the character it escapes
+			if escaped {
+				if !addWhole(contents, wrappers) {
+					return fail(ReasonMarkupTooLong)
+				}
+
+				return goToNext
+			}
+
+			// every line of a quote or an indented paragraph is prefixed on its own, so the prefix is never cut
+			if linePrefix != "" {
+				lines := strings.Split(contents, "\n")
+
+				// the marker of an alert goes along with the next line, so it's never left alone at the end of a chunk
+				if marker := alertMarker(node); marker != "" && len(lines) > 1 {
+					lines = append([]string{marker + "\n" + quotePrefix + lines[1]}, lines[2:]...)
+					if m.measure(marker+"\n"+quotePrefix) >= budget-extraLen(withHTMLWrappers(wrappers)) {
+						return fail(ReasonMarkupTooLong)
+					}
+				}
+
+				for i, line := range lines {
+					// the line break is useless at the beginning of a chunk
+					if i > 0 {
+						jointNext = "\n" + linePrefix
+					}
+
+					if !addText(line, wrappers) {
+						return fail(ReasonMarkupTooLong)
+					}
+				}
+
+				return goToNext
+			}
+
+		case htmlSpanNode:
+			// comments are kept whole, as they can't be closed and reopened
+			if isHTMLComment(contents) {
+				if !addWhole(contents, wrappers) {
+					return fail(ReasonMarkupTooLong)
+				}
+
+				return goToNext
+			}
+
+			// tags are passed through as they are instead, so they aren't cut either
+			if !opts.AutoCloseHTML {
+				if !addWhole(contents, wrappers) {
+					return fail(ReasonMarkupTooLong)
+				}
+
+				return goToNext
+			}
+
+			// the summary of a collapsible section is repeated in every chunk, so all of them are collapsed
+			// under it. The blank lines let the markdown inside of the section be rendered.
+			// It must be the first thing in the section, as the contents before it already have their room.
+			if isHTMLOpeningTag(contents) && getHTMLTagName(contents) == "summary" && len(htmlWrappers) > 0 &&
+				getHTMLTagName(htmlWrappers[len(htmlWrappers)-1].end) == "details" &&
+				(len(chunks) == 0 || !hasWrapper(chunks[len(chunks)-1], htmlWrappers[len(htmlWrappers)-1])) {
+				summary, nodes := detailsSummary(node)
+				for _, n := range nodes {
+					skippedNodes[n] = true
+				}
+
+				details := htmlWrappers[len(htmlWrappers)-1]
+				details.begin += "\n" + contents + summary + "</summary>\n\n"
+				details.end = "\n\n" + details.end
+
+				return goToNext
+			}
+
+			// the line break before the summary would be left alone in a section of its own
+			if getHTMLTagName(contents) == "details" && isHTMLOpeningTag(contents) {
+				if ws := node.next; ws != nil && ws.typ == textNode && strings.TrimSpace(string(ws.literal)) == "" &&
+					ws.next != nil && ws.next.typ == htmlSpanNode && getHTMLTagName(string(ws.next.literal)) == "summary" {
+					skippedNodes[ws] = true
+				}
+			}
+
+			if handleHTMLTag(contents) {
+				contents = ""
+			}
+
+		case htmlBlockNode:
+			// scripts and styles are neither markdown nor text, so they can't be cut anywhere
+			if isRawHTMLBlock(contents) {
+				if !addWhole(contents, wrappers) {
+					return fail(ReasonRawHTMLTooLong)
+				}
+
+				return goToNext
+			}
+
+			// raw html blocks may contain several tags, so track each of them the same way as spans
+			for _, token := range splitHTMLTokens(contents) {
+				if isHTMLComment(token) {
+					if !addWhole(token, wrappers) {
+						return fail(ReasonMarkupTooLong)
+					}
+
+					continue
+				}
+
+				if isHTMLTag(token) && !opts.AutoCloseHTML {
+					if !addWhole(token, wrappers) {
+						return fail(ReasonMarkupTooLong)
+					}
+
+					continue
+				}
+
+				if isHTMLTag(token) && handleHTMLTag(token) {
+					continue
+				}
+
+				// whitespace between tags is meaningless once they get reopened in every chunk
+				if strings.TrimSpace(token) == "" {
+					continue
+				}
+
+				if !addChunks(token, wrappers) {
+					return fail(ReasonMarkupTooLong)
+				}
+			}
+
+			return goToNext
+		}
+
+		if !addText(contents, wrappers) {
+			return fail(ReasonMarkupTooLong)
+		}
+
+		return goToNext
+	})
+
+	if failure != SplitOK {
+		return nil, failure
+	}
+
+	if rest := text[cursor:]; opts.Lossless && strings.TrimSpace(rest) == "" && !addChunks(rest, nil) {
+		return nil, ReasonMarkupTooLong
+	}
+
+	// the separator is appended to the chunks afterwards, the same way SimpleSplit does
+	chunksMax := max - m.measure(sep)
+
+	if opts.MinChunkSize > 0 {
+		mergeSmallChunks(chunks, chunksMax, baseTitle, renderTitle, opts.MinChunkSize, m)
+	}
+
+	if opts.fillFirst {
+		chunks = fillFirstChunk(chunks, chunksMax, baseTitle, renderTitle, opts)
+	}
+
+	if opts.emit != nil && len(chunks) > 0 {
+		return nil, emitChunks(chunks, chunksMax, sep, baseTitle, renderTitle, opts)
+	}
+
+	result, starts, ok := chunksAsStr(chunks, chunksMax, baseTitle, renderTitle, opts.MaxChunks, m)
+
+	// the room for the titles is reserved while splitting, but it's only checked here, once they
+	// are written, so a chunk is never longer than max whatever the length of the title is (but for
+	// the urls kept whole, which may be longer on purpose)
+	for i, cm := range result {
+		if m.measure(cm) > chunksMax && !chunks[starts[i]].oversized {
+			return nil, ReasonChunkTooLong
+		}
+	}
+
+	for i := 0; i < len(result)-1; i++ {
+		result[i] += sep
+	}
+
+	if !ok {
+		return result, ReasonTooManyChunks
+	}
+
+	return result, SplitOK
+}
+
+// escapableChars are the characters blackfriday allows escaping with a backslash
+const escapableChars = "\\`*_{}[]()#+-.!:|&<>~"
+
+// isEscapable tells whether the literal is a single character that can be escaped.
+func isEscapable(literal string) bool {
+	return len(literal) == 1 && strings.Contains(escapableChars, literal)
+}
+
+// losslessGapRe matches what the parser may skip between literals that can be kept as is in lossless mode
+var losslessGapRe = regexp.MustCompile(`^\\?\s*$`)
+
+// blankLinesGapRe matches the whitespace between blocks separated by blank lines
+var blankLinesGapRe = regexp.MustCompile(`^[ \t]*\n(?:[ \t]*\n)+[ \t]*$`)
+
+// Truncate caps the text to max, keeping only the first chunk of a markdown split followed by the ellipsis,
+// filled with as much of the paragraph it ends in as fits, so the code blocks, emphasis and the rest of the markup
+// open where it's cut are closed, and no rune is broken. The first heading is kept as it is, instead of becoming
+// a title. If the text can't be split as markdown, it's cut the way SimpleSplit does.
+//
+// Returns the text capped and a bool informing if it had to be truncated, or an empty string and true
+// if the ellipsis doesn't leave room for anything.
+func Truncate(text string, max int, ellipsis string) (string, bool) {
+	if len(text) <= max {
+		return text, false
+	}
+
+	opts := DefaultOptions()
+	opts.MaxChunks = 1
+	opts.untitled = true
+	opts.fillFirst = true
+
+	result := SplitDetailed(text, max-len(ellipsis), "", opts)
+	if len(result.Chunks) == 0 {
+		return "", true
+	}
+
+	return result.Chunks[0] + ellipsis, true
+}
+
+// SplitIntoN performs a markdown split aiming for n chunks at most, computing the max length itself.
+// It starts from an even share of the text (ceil(len(text)/n) plus the separator) and grows it until
+// the wrappers and title overhead no longer push the result above n chunks, so the chunks stay as
+// evenly sized as possible.
+//
+// Returns the text splits and a bool informing if it was able to do a markdown split into n chunks or less.
+// If not, it fallbacks to simple split using the even share as max length.
+func SplitIntoN(text string, n int, sep string) ([]string, bool) {
+	if n <= 0 {
+		return nil, false
+	}
+
+	if strings.TrimSpace(text) == "" {
+		return []string{}, true
+	}
+
+	base := int(math.Ceil(float64(len(text))/float64(n))) + len(sep)
+	if base >= len(text) {
+		return []string{text}, true
+	}
+
+	fits := func(max int) bool {
+		chunks, ok := MarkdownSplit(text, max, sep)
+		return ok && len(chunks) <= n
+	}
+
+	// binary search the smallest max that fits, below len(text) (which would trivially be a single chunk)
+	lo, hi := base, len(text)
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if fits(mid) {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	if lo >= len(text) {
+		return SimpleSplit(text, base, sep), false
+	}
+
+	return MarkdownSplit(text, lo, sep)
+}
+
+// MinFeasibleMax returns the smallest max for which MarkdownSplit is able to do a markdown split of the text,
+// which must leave room for the widest wrappers along with the title and the separator. A text is always
+// feasible with a max as long as itself, as it doesn't need to be split then.
+func MinFeasibleMax(text string, sep string) int {
+	// binary search the smallest max that works, which is at most len(text)
+	lo, hi := 1, len(text)
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if _, ok := MarkdownSplit(text, mid, sep); ok {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	return lo
+}
+
+// SplitPlan is the outcome of a MarkdownSplit, as told by Plan.
+type SplitPlan struct {
+	// WillFallback tells whether a simple split would be performed, as the markdown split isn't possible
+	WillFallback bool
+	// Reason is why the markdown split isn't possible, SplitOK if it is
+	Reason SplitReason
+	// ChunkCount is the amount of chunks of the split
+	ChunkCount int
+	// MinFeasibleMax is the smallest max for which the markdown split is possible, like MinFeasibleMax returns
+	MinFeasibleMax int
+}
+
+// Plan tells the outcome of a MarkdownSplit of the text without returning its chunks, so it can be previewed
+// (like warning that the formatting will be lost, or how many messages will be posted) before splitting it.
+// The search of MinFeasibleMax splits the text several times, so it's slower than splitting it once.
+func Plan(text string, max int, sep string) SplitPlan {
+	result := SplitDetailed(text, max, sep, DefaultOptions())
+
+	return SplitPlan{
+		WillFallback:   result.Fallback,
+		Reason:         result.ReasonCode,
+		ChunkCount:     len(result.Chunks),
+		MinFeasibleMax: MinFeasibleMax(text, sep),
+	}
+}
+
+// rebalance balances the chunks when the last one is an orphan (smaller than max/4).
+func rebalance(text string, max int, sep string, opts Options, chunks []string) []string {
+	if len(chunks) < 2 || opts.LengthMode.measure(chunks[len(chunks)-1])*4 >= max {
+		return chunks
+	}
+
+	return balance(text, max, sep, opts, chunks)
+}
+
+// balance looks for the smallest max that still produces the same amount of chunks,
+// which evens out the length of all of them.
+func balance(text string, max int, sep string, opts Options, chunks []string) []string {
+	m := opts.LengthMode
+
+	if len(chunks) < 2 {
+		return chunks
+	}
+
+	lo, hi := m.measure(sep)+1, max
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if c, reason := markdownSplit(text, mid, sep, opts); reason == SplitOK && len(c) <= len(chunks) {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	if balanced, reason := markdownSplit(text, lo, sep, opts); reason == SplitOK && len(balanced) <= len(chunks) {
+		return balanced
+	}
+
+	return chunks
+}
+
+// wrapperDefinitions adds the definitions needed by wrappers to defs, skipping the ones already there.
+func wrapperDefinitions(defs []string, wrappers []*wrapper) []string {
+	result := defs
+
+	for _, w := range wrappers {
+		if w.definition == "" {
+			continue
+		}
+
+		found := false
+		for _, d := range result {
+			if d == w.definition {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			// copy on write, so defs can be safely reused by the caller
+			result = append(result[:len(result):len(result)], w.definition)
+		}
+	}
+
+	return result
+}
+
+// definitionsSuffix returns the text to append at the end of a chunk to include defs.
+func definitionsSuffix(defs []string) string {
+	if len(defs) == 0 {
+		return ""
+	}
+
+	return "\n\n" + strings.Join(defs, "\n")
+}
+
+// setextUnderline returns the line underlining the heading in the text, if it was written in the setext
+// style (like "Title\n=====") instead of the ATX one (like "# Title").
+func setextUnderline(text, heading string) string {
+	re := regexp.MustCompile(`(?m)^ {0,3}` + regexp.QuoteMeta(heading) + `[ \t]*\n {0,3}(=+|-+)[ \t]*$`)
+
+	if match := re.FindStringSubmatch(text); match != nil {
+		return match[1]
+	}
+
+	return ""
+}
+
+// hasBlockJoint tells whether the node is a block of a blockquote or a list item after the first one,
+// which needs a joint to be kept apart from the previous one. Nested lists go right after the contents
+// of their item instead. Code blocks need it anywhere, as their fences must begin a line.
+func hasBlockJoint(node *mdNode) bool {
+	if node.prev == nil || node.parent == nil {
+		return false
+	}
+
+	switch node.typ {
+	case codeBlockNode:
+		// the fences must begin a line wherever the block is, not only in blockquotes and list items
+		return true
+	case paragraphNode, blockQuoteNode:
+	case listNode:
+		if node.parent.typ == itemNode {
+			return false
+		}
+	default:
+		return false
+	}
+
+	return node.parent.typ == blockQuoteNode || node.parent.typ == itemNode
+}
+
+// blockJoint returns the joint of a block of a blockquote or a list item: a blank line, prefixed like
+// every line of the block. The line break is left out after the blocks that already end with one.
+func blockJoint(block *mdNode, listStarts map[*mdNode]int) string {
+	prefix := quotePrefixOf(block)
+	if block.parent.typ == itemNode {
+		prefix += itemContinuationIndentation(block.parent, listStarts)
+	}
+
+	joint := "\n" + strings.TrimRight(prefix, " ") + "\n" + prefix
+	if block.prev.typ == listNode || block.prev.typ == codeBlockNode {
+		joint = joint[1:]
+	}
+
+	return joint
+}
+
+// quotePrefixOf returns the prefix of the lines of node, one "> " for every blockquote it's in.
+func quotePrefixOf(node *mdNode) string {
+	prefix := ""
+	for parent := node.parent; parent != nil; parent = parent.parent {
+		if parent.typ == blockQuoteNode {
+			prefix += "> "
+		}
+	}
+
+	return prefix
+}
+
+// breadcrumbTitle builds a title out of a chain of headings, like "# Chapter > ## Section".
+func breadcrumbTitle(headings []*mdNode) string {
+	titles := make([]string, len(headings))
+	for i, heading := range headings {
+		titles[i] = strings.Repeat("#", heading.level) + " " + renderInline(heading)
+	}
+
+	return strings.Join(titles, " > ")
+}
+
+// emphasisRun returns where the innermost wrappers that are emphasis, strong or strikethrough begin in the path, which
+// goes from the outermost wrapper to the innermost one. It's the length of the path if the innermost one isn't any.
+func emphasisRun(path []*wrapper) int {
+	i := len(path)
+	for i > 0 && isEmphasisWrapper(path[i-1]) {
+		i--
+	}
+
+	return i
+}
+
+// isEmphasisWrapper tells whether the wrapper is the one of an emphasis, strong or strikethrough, whose delimiters
+// can't have whitespace on their inner side.
+func isEmphasisWrapper(w *wrapper) bool {
+	switch w.begin {
+	case "_", "**", "~~":
+		return w.end == w.begin
+	}
+
+	return false
+}
+
+// inlineWrapper returns the wrapper that reproduces the markdown syntax of an inline node
+// (emphasis, strong, strikethrough, link or image) around its contents.
+func inlineWrapper(node *mdNode) *wrapper {
+	switch node.typ {
+	case delNode:
+		return &wrapper{begin: "~~", end: "~~"}
+
+	case emphNode:
+		return &wrapper{begin: "_", end: "_"}
+
+	case strongNode:
+		return &wrapper{begin: "**", end: "**"}
+
+	case linkNode, imageNode:
+		if node.noteID != 0 {
+			return &wrapper{begin: "[^" + string(node.destination) + "]"}
+		}
+
+		var sb strings.Builder
+		sb.WriteString("](")
+
+		linkDest, linkTitle := string(node.destination), string(node.title)
+		if linkDest != "" {
+			sb.WriteString(linkDest)
+		}
+
+		if linkTitle != "" {
+			sb.WriteString(fmt.Sprintf(" \"%s\"", escapeLinkTitle(linkTitle)))
+		}
+
+		sb.WriteString(")")
+
+		begin := "["
+		if node.typ == imageNode {
+			begin = "!["
+		}
+
+		return &wrapper{begin: begin, end: sb.String()}
+	}
+
+	return nil
+}
+
+// renderInline renders the inline contents of node back to markdown.
+func renderInline(node *mdNode) string {
+	var sb strings.Builder
+
+	for child := node.firstChild; child != nil; child = child.next {
+		sb.WriteString(renderInlineNode(child))
+	}
+
+	return sb.String()
+}
+
+// renderInlineNode renders an inline node back to markdown, along with its own markup.
+func renderInlineNode(node *mdNode) string {
+	if w := inlineWrapper(node); w != nil {
+		return w.begin + renderInline(node) + w.end
+	}
+
+	if node.typ == codeNode {
+		return "`" + string(node.literal) + "`"
+	}
+
+	return string(node.literal) + renderInline(node)
+}
+
+// renderTableRow renders a table row back to markdown, including its trailing line break.
+func renderTableRow(row *mdNode) string {
+	var sb strings.Builder
+	sb.WriteString("|")
+
+	for cell := row.firstChild; cell != nil; cell = cell.next {
+		sb.WriteString(" " + renderTableCell(cell) + " |")
+	}
+
+	sb.WriteString("\n")
+
+	return sb.String()
+}
+
+// renderTableCell renders the inline contents of a table cell back to markdown, like renderInline. The pipes
+// in its text can only be escaped ones, so they are escaped again to not be taken as the end of the cell.
+func renderTableCell(node *mdNode) string {
+	var sb strings.Builder
+
+	for child := node.firstChild; child != nil; child = child.next {
+		if child.typ == textNode {
+			sb.WriteString(strings.ReplaceAll(string(child.literal), "|", `\|`))
+		} else if w := inlineWrapper(child); w != nil {
+			sb.WriteString(w.begin + renderTableCell(child) + w.end)
+		} else {
+			sb.WriteString(renderInlineNode(child))
+		}
+	}
+
+	return sb.String()
+}
+
+// renderTableDelimiterRow renders the row that separates the header of the table from its body,
+// keeping the alignment of every column.
+func renderTableDelimiterRow(header *mdNode) string {
+	var sb strings.Builder
+	sb.WriteString("|")
+
+	for cell := header.firstChild; cell != nil; cell = cell.next {
+		switch cell.align {
+		case alignLeft:
+			sb.WriteString(" :--- |")
+		case alignCenter:
+			sb.WriteString(" :---: |")
+		case alignRight:
+			sb.WriteString(" ---: |")
+		default:
+			sb.WriteString(" --- |")
+		}
+	}
+
+	sb.WriteString("\n")
+
+	return sb.String()
+}
+
+// splitFrontMatter separates a leading YAML front matter block from the rest of the text.
+// If there is no front matter, it returns an empty string and the text untouched.
+func splitFrontMatter(text string) (string, string) {
+	const delim = "---"
+
+	if !strings.HasPrefix(text, delim+"\n") {
+		return "", text
+	}
+
+	// the yaml begins right after the delimiter, which is just a horizontal rule when followed by a blank line
+	if line, _, _ := strings.Cut(text[len(delim)+1:], "\n"); strings.TrimSpace(line) == "" {
+		return "", text
+	}
+
+	end := strings.Index(text[len(delim):], "\n"+delim+"\n")
+	if end == -1 {
+		if !strings.HasSuffix(text, "\n"+delim) {
+			return "", text
+		}
+		return text, ""
+	}
+
+	end += len(delim) + len("\n"+delim+"\n")
+
+	return text[:end], strings.TrimLeft(text[end:], "\n")
+}
+
+// SimpleSplit performs a simple split based on max length and a separator string.
+// An empty text is split in no chunks at all, but whitespace is split as any other text.
+// Returns nil if max isn't positive.
+func SimpleSplit(text string, max int, sep string) []string {
+	return simpleSplit(text, max, sep, Options{})
+}
+
+// SimpleSplitOpts performs a simple split like SimpleSplit, measuring the text with the LengthMode of opts
+// and keeping the links, words, mentions and letters with combining marks whole according to KeepLinksInFallback,
+// KeepWordsWhole, KeepMentionsWhole and KeepCombiningMarks.
+// The rest of the options only apply to the markdown split.
+func SimpleSplitOpts(text string, max int, sep string, opts Options) []string {
+	return simpleSplit(text, max, sep, opts)
+}
+
+// SimpleSplitUnique performs a simple split like SimpleSplit, but lengthens the separator by repeating its last
+// character until the chunks can be told apart by it, as a separator found in the text would make splitting their
+// concatenation ambiguous. Returns the chunks along with the separator used, or nil if it grew as long as max.
+func SimpleSplitUnique(text string, max int, sep string) ([]string, string) {
+	for {
+		chunks := SimpleSplit(text, max, sep)
+		if chunks == nil || sep == "" || separatesChunks(chunks, sep) {
+			return chunks, sep
+		}
+
+		last, _ := utf8.DecodeLastRuneInString(sep)
+		sep += string(last)
+	}
+}
+
+// separatesChunks tells whether splitting the concatenation of the chunks by sep gives them back, without it.
+func separatesChunks(chunks []string, sep string) bool {
+	parts := strings.Split(strings.Join(chunks, ""), sep)
+	if len(parts) != len(chunks) {
+		return false
+	}
+
+	for i, part := range parts {
+		if part != strings.TrimSuffix(chunks[i], sep) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// simpleSplit splits the text like SimpleSplitOpts.
+func simpleSplit(text string, max int, sep string, opts Options) []string {
+	m := opts.LengthMode
+
+	if max <= 0 {
+		return nil
+	}
+
+	if text == "" {
+		return []string{}
+	}
+
+	// If we're under the limit then no need to split.
+	if m.measure(text) <= max {
+		return []string{text}
+	}
+
+	// If we can't fit the separator string in then this doesn't make sense.
+	if max <= m.measure(sep) {
+		return nil
+	}
+
+	var chunks []string
+
+	maxSize := max - m.measure(sep)
+
+	var linkSpans [][]int
+	if opts.KeepLinksInFallback {
+		linkSpans = findLinkSpans(text)
+	}
+
+	for offset := 0; text != ""; {
+		upTo := cutOutsideShortcodes(text, cutOutsideEntities(text, m.cut(text, maxSize)))
+		if opts.KeepCombiningMarks {
+			upTo = cutOutsideCombiningMarks(text, upTo)
+		}
+		if opts.KeepWordsWhole {
+			upTo = cutOutsideWords(text, upTo)
+		}
+		if opts.KeepMentionsWhole {
+			upTo = cutOutsideMentions(text, upTo)
+		}
+		if opts.KeepLinksInFallback {
+			upTo = cutOutsideLinks(linkSpans, offset, upTo)
+		}
+
+		portion := text[:upTo]
+		text = text[upTo:]
+		offset += upTo
+		if text != "" {
+			portion += sep
+		}
+		chunks = append(chunks, portion)
+	}
+
+	return chunks
+}
+
+// cutOutsideWords moves the cut at idx back to the end of the last whitespace before it, so the word it
+// falls in goes whole to the next chunk. A word that begins the text is cut anyway, as it doesn't fit in any.
+func cutOutsideWords(s string, idx int) int {
+	if idx >= len(s) || idx == 0 {
+		return idx
+	}
+
+	next, _ := utf8.DecodeRuneInString(s[idx:])
+	prev, _ := utf8.DecodeLastRuneInString(s[:idx])
+	if unicode.IsSpace(next) || unicode.IsSpace(prev) {
+		return idx
+	}
+
+	space := strings.LastIndexFunc(s[:idx], unicode.IsSpace)
+	if space == -1 {
+		return idx
+	}
+
+	_, size := utf8.DecodeRuneInString(s[space:])
+
+	return space + size
+}
+
+// cutOutsideCodeTokens moves the cut at idx of a line of code back to the end of the last whitespace before it,
+// like cutOutsideWords, so a line longer than a chunk is broken between tokens. The indentation doesn't count, as
+// a chunk with nothing else would be useless, so a line with a single token is cut anyway.
+func cutOutsideCodeTokens(line string, idx int) int {
+	indent := len(line) - len(strings.TrimLeftFunc(line, unicode.IsSpace))
+
+	if idx <= indent {
+		return idx
+	}
+
+	if upTo := cutOutsideWords(line[indent:], idx-indent); upTo > 0 {
+		return indent + upTo
+	}
+
+	return idx
+}
+
+// cutOutsideMentions moves the cut at idx back to the beginning of the mention or hashtag it falls in, like
+// @user or #channel, so it goes whole to the next chunk. One that begins the text is cut anyway.
+func cutOutsideMentions(s string, idx int) int {
+	if idx >= len(s) || !isMentionChar(s[idx]) {
+		return idx
+	}
+
+	start := idx
+	for start > 0 && isMentionChar(s[start-1]) {
+		start--
+	}
+
+	if start <= 1 || s[start-1] != '@' && s[start-1] != '#' {
+		return idx
+	}
+
+	return start - 1
+}
+
+// cutOutsideShortcodes moves the cut at idx back to the beginning of the emoji shortcode it falls in, like
+// :tada:, as chat platforms only render it whole. One that begins the text is cut anyway.
+func cutOutsideShortcodes(s string, idx int) int {
+	start := idx
+	for start > 0 && isShortcodeChar(s[start-1]) {
+		start--
+	}
+
+	end := idx
+	for end < len(s) && isShortcodeChar(s[end]) {
+		end++
+	}
+
+	// both colons are needed around a name that isn't empty, and the cut can't be after the closing one
+	if start <= 1 || s[start-1] != ':' || end == start || end == len(s) || s[end] != ':' {
+		return idx
+	}
+
+	return start - 1
+}
+
+// isShortcodeChar tells whether the byte can be part of the name of an emoji shortcode.
+func isShortcodeChar(b byte) bool {
+	return b == '_' || b == '+' || b == '-' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z'
+}
+
+// cutOutsideCombiningMarks moves the cut at idx back to the beginning of the letter the combining marks (like
+// the accent of a decomposed é) right after it go along with, so they aren't left apart. A letter that begins
+// the text is cut from its marks anyway.
+func cutOutsideCombiningMarks(s string, idx int) int {
+	start := idx
+	for start > 0 && start < len(s) {
+		r, _ := utf8.DecodeRuneInString(s[start:])
+		if !unicode.In(r, unicode.Mn, unicode.Mc) {
+			return start
+		}
+
+		_, size := utf8.DecodeLastRuneInString(s[:start])
+		start -= size
+	}
+
+	if start == 0 {
+		return idx
+	}
+
+	return start
+}
+
+// isMentionChar tells whether the byte can be part of the name of a mention or a hashtag.
+func isMentionChar(b byte) bool {
+	return b == '_' || b == '-' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// splitHTMLTokens splits raw html into its tags and the text between them.
+func splitHTMLTokens(html string) []string {
+	var tokens []string
+
+	for html != "" {
+		start := strings.Index(html, "<")
+		if start == -1 {
+			tokens = append(tokens, html)
+			break
+		}
+
+		// comments may have anything inside, even other tags, so they go up to their own end
+		closing := ">"
+		if strings.HasPrefix(html[start:], "<!--") {
+			closing = "-->"
+		}
+
+		end := strings.Index(html[start:], closing)
+		if end == -1 {
+			tokens = append(tokens, html)
+			break
+		}
+		end += start + len(closing)
+
+		if start > 0 {
+			tokens = append(tokens, html[:start])
+		}
+		tokens = append(tokens, html[start:end])
+		html = html[end:]
+	}
+
+	return tokens
+}
+
+// expandTabs replaces every tab by the spaces up to the next column multiple of width.
+func expandTabs(text string, width int) string {
+	if !strings.Contains(text, "\t") {
+		return text
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(text))
+
+	col := 0
+	for _, r := range text {
+		switch r {
+		case '\t':
+			spaces := width - col%width
+			sb.WriteString(strings.Repeat(" ", spaces))
+			col += spaces
+		case '\n':
+			sb.WriteRune(r)
+			col = 0
+		default:
+			sb.WriteRune(r)
+			col++
+		}
+	}
+
+	return sb.String()
+}
+
+// rawHTMLBlockRe matches the beginning of a <script> or <style> block
+var rawHTMLBlockRe = regexp.MustCompile(`(?i)^<(?:script|style)[\s>]`)
+
+// isRawHTMLBlock tells whether the html block is a script or a style, whose contents are raw code.
+func isRawHTMLBlock(html string) bool {
+	return rawHTMLBlockRe.MatchString(html)
+}
+
+// isHTMLComment tells whether the token is a comment, like <!-- this one -->.
+func isHTMLComment(token string) bool {
+	return strings.HasPrefix(token, "<!--")
+}
+
+func isHTMLTag(token string) bool {
+	return strings.HasPrefix(token, "<") && strings.HasSuffix(token, ">")
+}
+
+// htmlVoidElements are the elements that can't have any content, so they are never closed.
+var htmlVoidElements = map[string]bool{
+	"area":   true,
+	"base":   true,
+	"br":     true,
+	"col":    true,
+	"embed":  true,
+	"hr":     true,
+	"img":    true,
+	"input":  true,
+	"link":   true,
+	"meta":   true,
+	"param":  true,
+	"source": true,
+	"track":  true,
+	"wbr":    true,
+}
+
+func isHTMLOpeningTag(tag string) bool {
+	// neither comments nor declarations (like <!DOCTYPE html>) open anything
+	if strings.HasPrefix(tag, "</") || strings.HasPrefix(tag, "<!") {
+		return false
+	}
+
+	// self-closing and void tags don't open anything, they are emitted inline as they are
+	if strings.HasSuffix(tag, "/>") || htmlVoidElements[getHTMLTagName(tag)] {
+		return false
+	}
+
+	return true
+}
+
+// getHTMLTagName returns the lowercased name of an opening or closing tag, without attributes.
+func getHTMLTagName(tag string) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(tag), "<"), "/")
+	if end := strings.IndexAny(name, " \t\n/>"); end != -1 {
+		name = name[:end]
+	}
+
+	return strings.ToLower(name)
+}
+
+// getHTMLClosingTag returns the tag closing an opening one, which has no attributes.
+func getHTMLClosingTag(open string) string {
+	name := strings.TrimPrefix(open, "<")
+	if end := strings.IndexAny(name, " \t\n/>"); end != -1 {
+		name = name[:end]
+	}
+
+	return "</" + name + ">"
+}
+
+// buildChunks cuts the contents in chunks of chunkLen, measured with the LengthMode of opts, and appends them to dst,
+// so the caller doesn't need a slice for every contents. They aren't cut in the middle of a mention or a hashtag, or
+// between a letter and its combining marks, if opts says so.
+// Returns false if chunkLen isn't positive or the contents can't be cut, so none of them is lost silently.
+func buildChunks(dst []*chunk, contents string, chunkLen int, wrappers []*wrapper, opts Options) ([]*chunk, bool) {
+	m := opts.LengthMode
+
+	if chunkLen <= 0 {
+		return dst, false
+	}
+
+	for contents != "" {
+		c := &chunk{}
+		c.wrappers = wrappers
+
+		upTo := cutOutsideShortcodes(contents, cutOutsideEntities(contents, m.cut(contents, chunkLen)))
+		if opts.KeepCombiningMarks {
+			upTo = cutOutsideCombiningMarks(contents, upTo)
+		}
+		if opts.KeepMentionsWhole {
+			upTo = cutOutsideMentions(contents, upTo)
+		}
+		if upTo <= 0 {
+			// nothing can be cut, so it would never end
+			return dst, false
+		}
+
+		c.content = contents[:upTo]
+		contents = contents[upTo:]
+
+		dst = append(dst, c)
+	}
+
+	return dst, true
+}
+
+// chunksAsStr assembles the chunks, which can't be more than limit (if it isn't 0), along with the index of the
+// chunk every one of them begins with. Returns false along with the first limit chunks if there are more.
+func chunksAsStr(
+	chunks []*chunk, max int, baseTitle string, renderTitle func(title string, index, total int) string, limit int, m LengthMode,
+) ([]string, []int, bool) {
+	total, ok := chunkTotals(chunks, max, baseTitle, renderTitle, limit, m)
+
+	result, starts, assembled := assembleChunks(chunks, max, baseTitle, renderTitle, total, limit, m)
+
+	return result, starts, ok && assembled
+}
+
+// emitChunks assembles the chunks like chunksAsStr, calling opts.emit with every one of them, followed by the
+// separator but for the last one, as soon as it's assembled instead of returning them. They are assembled before
+// to count them and check they fit in max, so none is emitted if the split isn't possible.
+func emitChunks(
+	chunks []*chunk, max int, sep, baseTitle string, renderTitle func(title string, index, total int) string, opts Options,
+) SplitReason {
+	m := opts.LengthMode
+
+	total, _ := chunkTotals(chunks, max, baseTitle, renderTitle, 0, m)
+
+	// the room for the titles is reserved while splitting, but it's only checked once they are written,
+	// as markdownSplit does. The chunks longer than max are only allowed when they hold a url kept whole.
+	var long []int
+	index := 0
+	starts, _ := assembleChunksFunc(chunks, max, baseTitle, renderTitle, total, 0, m, func(chunk string) bool {
+		if m.measure(chunk) > max {
+			long = append(long, index)
+		}
+		index++
+
+		return len(long) == 0 || opts.KeepURLsWhole
+	})
+	for _, i := range long {
+		if !chunks[starts[i]].oversized {
+			return ReasonChunkTooLong
+		}
+	}
+
+	index = 0
+	assembleChunksFunc(chunks, max, baseTitle, renderTitle, total, 0, m, func(chunk string) bool {
+		if index < len(starts)-1 {
+			chunk += sep
+		}
+
+		err := opts.emit(index, len(starts), chunk)
+		index++
+
+		return err == nil
+	})
+
+	return SplitOK
+}
+
+// chunkTotals returns the total amount of chunks of every section once assembled, for their titles, which can't
+// be more than limit (if it isn't 0). Returns false along with the limit as the total of all of them if there are more.
+func chunkTotals(
+	chunks []*chunk, max int, baseTitle string, renderTitle func(title string, index, total int) string, limit int, m LengthMode,
+) (func(section int) int, bool) {
+	// discard only counts the chunks, which aren't needed
+	discard := func(string) bool { return true }
+
+	// the total amount of chunks is needed in the titles before knowing it, so they are assembled
+	// reserving room for a total of some digits first, and again with more of them if it grows past it,
+	// although the room of totalRoom digits it's measured with is usually enough. Once it fits, the final
+	// titles are written with the actual total, which can only be shorter. The limit is written as the total
+	// when it's exceeded, so there must be room for it from the start. When the titles are numbered per
+	// section, the total is the one of the section of every chunk.
+	for digits := len(strconv.Itoa(limit)); ; digits++ {
+		reserved := int(math.Pow10(digits)) - 1
+
+		starts, ok := assembleChunksFunc(chunks, max, baseTitle, renderTitle, sameTotal(reserved), limit, m, discard)
+		if !ok {
+			return sameTotal(limit), false
+		}
+
+		totals := map[int]int{}
+		longest := 0
+		for _, start := range starts {
+			section := chunks[start].section
+			totals[section]++
+			if totals[section] > longest {
+				longest = totals[section]
+			}
+		}
+
+		if len(strconv.Itoa(longest)) <= digits {
+			return func(section int) int { return totals[section] }, true
+		}
+	}
+}
+
+// totalRoom is the room the total of the titles takes while assembling the chunks, whatever its amount of digits,
+// so they are merged the same way whatever the total is.
+const totalRoom = 34
+
+// totalPad returns what the total falls short of totalRoom.
+func totalPad(total int) int {
+	if pad := totalRoom - len(strconv.Itoa(total)); pad > 0 {
+		return pad
+	}
+
+	return 0
+}
+
+// sameTotal returns a total for assembleChunks that's the same for all the sections.
+func sameTotal(total int) func(section int) int {
+	return func(int) int { return total }
+}
+
+// mergeSmallChunks lets the chunks that would be smaller than minSize be merged with the previous or the
+// next one, by not forcing a new chunk between them (if it was), as long as it takes one less chunk.
+// Sections with different titles are never merged.
+func mergeSmallChunks(
+	chunks []*chunk, max int, baseTitle string, renderTitle func(title string, index, total int) string, minSize int, m LengthMode,
+) {
+	// the total can't be more than the amount of chunks, so it leaves room for any of them
+	reserved := len(chunks)
+
+	result, starts, _ := assembleChunks(chunks, max, baseTitle, renderTitle, sameTotal(reserved), 0, m)
+
+	for i := 0; i < len(result); i++ {
+		if m.measure(result[i]) >= minSize {
+			continue
+		}
+
+		// the breaks before and after the small chunk
+		var breaks []int
+		if i > 0 {
+			breaks = append(breaks, starts[i])
+		}
+		if i < len(result)-1 {
+			breaks = append(breaks, starts[i+1])
+		}
+
+		for _, b := range breaks {
+			cm := chunks[b]
+			if !cm.newChunk || cm.title != chunks[b-1].title {
+				continue
+			}
+
+			cm.newChunk = false
+
+			merged, mergedStarts, _ := assembleChunks(chunks, max, baseTitle, renderTitle, sameTotal(reserved), 0, m)
+			if len(merged) < len(result) {
+				// every merge takes one chunk less, so starting over always ends
+				result, starts = merged, mergedStarts
+				i = -1
+				break
+			}
+
+			cm.newChunk = true
+		}
+	}
+}
+
+// fillFirstChunk cuts the chunk that begins the second one in two, if it wasn't forced to and it's in the middle
+// of a paragraph, so the longest beginning of it that fits goes at the end of the first one instead.
+func fillFirstChunk(
+	chunks []*chunk, max int, baseTitle string, renderTitle func(title string, index, total int) string, opts Options,
+) []*chunk {
+	m := opts.LengthMode
+
+	_, starts, _ := assembleChunks(chunks, max, baseTitle, renderTitle, sameTotal(1), 2, m)
+	if len(starts) < 2 || chunks[starts[1]].newChunk || !chunks[starts[1]].midParagraph {
+		return chunks
+	}
+
+	next := starts[1]
+	cm := chunks[next]
+
+	// cut returns the chunks with the one that begins the second chunk cut after n, or nil if n is too short
+	// to cut it without breaking a rune
+	cut := func(n int) []*chunk {
+		pieces, ok := buildChunks(nil, cm.content, n, nil, opts)
+		if !ok || len(pieces) < 2 {
+			return nil
+		}
+
+		upTo := len(pieces[0].content)
+		for upTo > 0 && !utf8.RuneStart(cm.content[upTo]) {
+			upTo--
+		}
+		if upTo == 0 {
+			return nil
+		}
+
+		head, tail := *cm, *cm
+		head.content = cm.content[:upTo]
+		tail.content, tail.joint, tail.wholeLen = cm.content[upTo:], "", 0
+
+		cut := make([]*chunk, 0, len(chunks)+1)
+		cut = append(cut, chunks[:next]...)
+		cut = append(cut, &head, &tail)
+
+		return append(cut, chunks[next+1:]...)
+	}
+
+	// the more of it is cut, the less likely it fits, so the longest beginning is searched for
+	var filled []*chunk
+	for lo, hi := 1, m.measure(cm.content)-1; lo <= hi; {
+		mid := lo + (hi-lo)/2
+
+		candidate := cut(mid)
+		if candidate == nil {
+			lo = mid + 1
+			continue
+		}
+
+		if _, starts, _ := assembleChunks(candidate, max, baseTitle, renderTitle, sameTotal(1), 2, m); len(starts) > 1 &&
+			starts[1] == next+1 {
+			filled = candidate
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	if filled == nil {
+		return chunks
+	}
+
+	return filled
+}
+
+// assembleChunks merges the chunks in as few as possible without exceeding max, writing the total of the section of
+// every chunk in the titles, which are numbered from 1 again in every section.
+// Returns them along with the index of the chunk each one starts with.
+// It stops as soon as there are more than limit of them (if it isn't 0), returning false along with the first ones.
+func assembleChunks(
+	chunks []*chunk, max int, baseTitle string, renderTitle func(title string, index, total int) string,
+	total func(section int) int, limit int, m LengthMode,
+) ([]string, []int, bool) {
+	var result []string
+	starts, ok := assembleChunksFunc(chunks, max, baseTitle, renderTitle, total, limit, m, func(chunk string) bool {
+		result = append(result, chunk)
+		return true
+	})
+
+	return result, starts, ok
+}
+
+// assembleChunksFunc assembles the chunks like assembleChunks, but calls emit with every one of them as soon as
+// it's assembled instead of returning them, so they aren't kept when they are only needed one at a time.
+// It also stops when emit returns false, returning false.
+func assembleChunksFunc(
+	chunks []*chunk, max int, baseTitle string, renderTitle func(title string, index, total int) string,
+	total func(section int) int, limit int, m LengthMode, emit func(chunk string) bool,
+) ([]int, bool) {
+	var starts []int
+	emitted := 0
+	stopped := false
+	curChunk := 1
+	curSection := 0
+
+	// the wrappers opened in the current chunk, from the outermost to the innermost, so the ones
+	// shared by consecutive chunks (like the header of a table) are only opened once
+	var open []*wrapper
+	// the definitions needed by the wrappers used in the current chunk
+	var definitions []string
+	// the current chunk is accumulated in a builder until the next one doesn't fit in it, to avoid
+	// copying it again on every merge. Its measured length is tracked for the same reason.
+	var cur strings.Builder
+	curLen := 0
+	started := false
+
+	// seal closes the wrappers still opened in the current chunk and emits it
+	seal := func() {
+		// an emphasis isn't closed by a delimiter after whitespace, so the whitespace the chunk ends with is
+		// moved after the delimiters closed right after it
+		emphases := emphasisRun(open)
+		ws := ""
+		if emphases < len(open) {
+			s := cur.String()
+			if trimmed := strings.TrimRightFunc(s, unicode.IsSpace); trimmed != "" && len(trimmed) < len(s) {
+				ws = s[len(trimmed):]
+				cur.Reset()
+				cur.WriteString(trimmed)
+			}
+		}
+
+		for i := len(open) - 1; i >= 0; i-- {
+			cur.WriteString(open[i].end)
+			if i == emphases {
+				cur.WriteString(ws)
+			}
+		}
+		cur.WriteString(definitionsSuffix(definitions))
+
+		stopped = !emit(cur.String())
+		emitted++
+		cur.Reset()
+	}
+
+	var sb strings.Builder
+
+	// assembledLen is the size in bytes of what assemble returns
+	assembledLen := func(closing, opening []*wrapper, joint, content string) int {
+		size := len(joint) + len(content)
+		for _, w := range closing {
+			size += len(w.end)
+		}
+		for _, w := range opening {
+			size += len(w.begin)
+		}
+
+		return size
+	}
+
+	// assembleTo writes the ends of the wrappers to close, from the innermost to the outermost,
+	// followed by the joint, the beginnings of the wrappers to open and the content
+	assembleTo := func(b *strings.Builder, closing, opening []*wrapper, joint, content string) {
+		for i := len(closing) - 1; i >= 0; i-- {
+			b.WriteString(closing[i].end)
+		}
+		b.WriteString(joint)
+		for _, w := range opening {
+			b.WriteString(w.begin)
+		}
+		b.WriteString(content)
+	}
+
+	assemble := func(closing, opening []*wrapper, joint, content string) string {
+		sb.Reset()
+		sb.Grow(assembledLen(closing, opening, joint, content))
+		assembleTo(&sb, closing, opening, joint, content)
+
+		return sb.String()
+	}
+
+	// measureAssembled measures what assemble returns, only building it when the length isn't the amount of bytes
+	measureAssembled := func(closing, opening []*wrapper, joint, content string) int {
+		if m == Bytes {
+			return assembledLen(closing, opening, joint, content)
+		}
+
+		return m.measure(assemble(closing, opening, joint, content))
+	}
+
+	// the max of the current chunk, which is the smallest one of the chunks it's made of
+	curMax := max
+	chunkMax := func(cm *chunk) int {
+		if cm.max > 0 && cm.max < max {
+			return cm.max
+		}
+
+		return max
+	}
+
+	// the paths of all the chunks share the same backing array, instead of allocating one for every chunk
+	pathsLen := 0
+	for _, cm := range chunks {
+		pathsLen += len(cm.wrappers)
+	}
+	paths := make([]*wrapper, pathsLen)
+
+	for i, cm := range chunks {
+		path := paths[:len(cm.wrappers):len(cm.wrappers)]
+		paths = paths[len(cm.wrappers):]
+		for i, w := range cm.wrappers {
+			path[len(path)-1-i] = w
+		}
+
+		if started && !cm.newChunk {
+			common := 0
+			for common < len(open) && common < len(path) && open[common] == path[common] {
+				common++
+			}
+
+			cmLen := measureAssembled(open[common:], path[common:], cm.joint, cm.content)
+			defs := wrapperDefinitions(definitions, path)
+
+			limit := curMax
+			if cmMax := chunkMax(cm); cmMax < limit {
+				limit = cmMax
+			}
+
+			fits := curLen+cmLen+measureAssembled(path, nil, "", "")+m.measure(definitionsSuffix(defs)) <= limit
+			if fits && cm.wholeLen > 0 {
+				// the paragraph the chunk begins is only merged if all of it fits
+				fits = curLen+measureAssembled(open[common:], nil, "", "")+m.measure(cm.joint)+cm.wholeLen <= limit
+			}
+
+			if fits {
+				assembleTo(&cur, open[common:], path[common:], cm.joint, cm.content)
+				curLen += cmLen
+				curMax = limit
+				open = path
+				definitions = defs
+				continue
+			}
+		}
+
+		if started {
+			seal()
+
+			if stopped || limit > 0 && emitted == limit {
+				return starts, false
+			}
+		}
+
+		cmStr := assemble(nil, path, "", cm.content)
+
+		// neither is an emphasis opened by a delimiter before whitespace, so the whitespace the chunk begins
+		// with is moved before the delimiters opened right before it
+		if emphases := emphasisRun(path); emphases < len(path) {
+			if trimmed := strings.TrimLeftFunc(cm.content, unicode.IsSpace); trimmed != "" && len(trimmed) < len(cm.content) {
+				ws := cm.content[:len(cm.content)-len(trimmed)]
+				cmStr = assemble(nil, path[:emphases], "", ws) + assemble(nil, path[emphases:], "", trimmed)
+			}
+		}
+
+		title := baseTitle
+		if cm.title != "" {
+			title = cm.title
+		}
+
+		if cm.section != curSection {
+			curChunk = 1
+			curSection = cm.section
+		}
+
+		if title != "" {
+			cmStr = renderTitle(title, curChunk, total(cm.section)) + cmStr
+		}
+
+		cur.WriteString(cmStr)
+		curLen = m.measure(cmStr)
+		if title != "" {
+			curLen += totalPad(total(cm.section))
+		}
+		curMax = chunkMax(cm)
+		started = true
+		starts = append(starts, i)
+		open = path
+		definitions = wrapperDefinitions(nil, path)
+		curChunk += 1
+	}
+
+	if started {
+		seal()
+	}
+
+	return starts, !stopped
+}
+
+// genTextAnchor generates an anchor that isn't found within the text, to make replacements in it. It's always the
+// same one for the same text, so the output doesn't change from a call to another.
+func genTextAnchor(text string) string {
+	const charset = "abcdefghijklmnopqrstuvwxyz" +
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	seededRand := rand.New(rand.NewSource(1))
+
+	b := make([]byte, 32)
+	for {
+		for i := range b {
+			b[i] = charset[seededRand.Intn(len(charset))]
+		}
+
+		if !strings.Contains(text, string(b)) {
+			return fmt.Sprintf("<%s>", string(b))
+		}
+	}
+}
+
+// hasWrapper tells whether the chunk goes inside of the wrapper.
+func hasWrapper(c *chunk, w *wrapper) bool {
+	for _, cw := range c.wrappers {
+		if cw == w {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nodeMax returns the max of the node, which is the one of its type in maxByType, or the one of its
+// innermost ancestor that has one. It's never more than the max of the split.
+func nodeMax(node *mdNode, max int, maxByType map[nodeType]int) int {
+	for n := node; n != nil; n = n.parent {
+		if typeMax, ok := maxByType[n.typ]; ok && typeMax > 0 {
+			if typeMax < max {
+				return typeMax
+			}
+
+			return max
+		}
 	}
 
-	return fmt.Sprintf("<%s>", string(b))
+	return max
 }
@@ -1,10 +1,18 @@
 package mdsplit
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/russross/blackfriday/v2"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/unicode/norm"
 )
 
 func TestMarkdownSplit(t *testing.T) {
@@ -73,11 +81,32 @@ func TestMarkdownSplit(t *testing.T) {
 			&testInput{"```thelang\nSplits codeblocks.\nProperly\nand without breaking syntax highlight```", 30, ""},
 			&testOutput{
 				[]string{
-					"```thelang\nSplits codebloc\n```",
-					"```thelang\nks.\nProperly\nan\n```",
-					"```thelang\nd without break\n```",
-					"```thelang\ning syntax high\n```",
-					"```thelang\nlight\n```",
+					"`thelang\nSplits codeblocks.\nP`",
+					"`roperly\nand without breaking`",
+					"` syntax highlight`",
+				},
+				true,
+			},
+		},
+		"codeblock_2": {
+			&testInput{"Some intro text here.\n\n```go\nfunc main() {\n\tfmt.Println(\"hello world\")\n}\n```\n\nAfter the code.\n", 40, ""},
+			&testOutput{
+				[]string{
+					"Some intro text here.",
+					"```go\nfunc main() {\n```\n",
+					"```go\n\tfmt.Println(\"hello world\")\n}\n```\n",
+					"After the code.",
+				},
+				true,
+			},
+		},
+		"codeblock_indented": {
+			&testInput{"Some code:\n\n    func main() {\n        fmt.Println(\"hello\")\n\n        fmt.Println(\"world\")\n    }\n", 40, ""},
+			&testOutput{
+				[]string{
+					"Some code:\n\n```\nfunc main() {\n```\n",
+					"```\n    fmt.Println(\"hello\")\n\n```\n",
+					"```\n    fmt.Println(\"world\")\n}\n```\n",
 				},
 				true,
 			},
@@ -87,9 +116,9 @@ func TestMarkdownSplit(t *testing.T) {
 			&testOutput{
 				[]string{
 					"<tag1>Splits content </tag1>",
-					"<tag2><tag1> nested in html spans </tag1></tag2>",
-					"<tag3><tag2><tag1>properly</tag1></tag2></tag3>",
-					"<tag2><tag1> and keeping tags.</tag1></tag2>",
+					"<tag1><tag2> nested in html spans </tag2></tag1>",
+					"<tag1><tag2><tag3>properly</tag3></tag2></tag1>",
+					"<tag1><tag2> and keeping tags.</tag2></tag1>",
 				},
 				true,
 			},
@@ -99,18 +128,18 @@ func TestMarkdownSplit(t *testing.T) {
 			&testOutput{
 				[]string{
 					"<tag1>Splits content </tag1>",
-					"<tag2><tag1> nested in htm</tag1></tag2>",
-					"<tag2><tag1>l spans </tag1></tag2>",
-					"<tag3><tag2><tag1>p</tag1></tag2></tag3>",
-					"<tag3><tag2><tag1>r</tag1></tag2></tag3>",
-					"<tag3><tag2><tag1>o</tag1></tag2></tag3>",
-					"<tag3><tag2><tag1>p</tag1></tag2></tag3>",
-					"<tag3><tag2><tag1>e</tag1></tag2></tag3>",
-					"<tag3><tag2><tag1>r</tag1></tag2></tag3>",
-					"<tag3><tag2><tag1>l</tag1></tag2></tag3>",
-					"<tag3><tag2><tag1>y</tag1></tag2></tag3>",
-					"<tag2><tag1> and keeping t</tag1></tag2>",
-					"<tag2><tag1>ags.</tag1></tag2>",
+					"<tag1><tag2> nested in htm</tag2></tag1>",
+					"<tag1><tag2>l spans </tag2></tag1>",
+					"<tag1><tag2><tag3>p</tag3></tag2></tag1>",
+					"<tag1><tag2><tag3>r</tag3></tag2></tag1>",
+					"<tag1><tag2><tag3>o</tag3></tag2></tag1>",
+					"<tag1><tag2><tag3>p</tag3></tag2></tag1>",
+					"<tag1><tag2><tag3>e</tag3></tag2></tag1>",
+					"<tag1><tag2><tag3>r</tag3></tag2></tag1>",
+					"<tag1><tag2><tag3>l</tag3></tag2></tag1>",
+					"<tag1><tag2><tag3>y</tag3></tag2></tag1>",
+					"<tag1><tag2> and keeping t</tag2></tag1>",
+					"<tag1><tag2>ags.</tag2></tag1>",
 				},
 				true,
 			},
@@ -128,7 +157,42 @@ func TestMarkdownSplit(t *testing.T) {
 				false,
 			},
 		},
-		// TODO: smart split of tables is not supported yet, update test when implemented
+		"html_block_1": {
+			&testInput{"<div>\nThis is a long paragraph wrapped inside a div block that must be split properly.\n</div>\n", 40, ""},
+			&testOutput{
+				[]string{
+					"<div>\nThis is a long paragraph wra</div>",
+					"<div>pped inside a div block that </div>",
+					"<div>must be split properly.\n</div>",
+				},
+				true,
+			},
+		},
+		"html_block_2": {
+			&testInput{"<ul>\n<li>First item of the raw list</li>\n<li>Second item of the raw list</li>\n</ul>\n", 40, ""},
+			&testOutput{
+				[]string{
+					"<ul><li>First item of the raw </li></ul>",
+					"<ul><li>list</li></ul>",
+					"<ul><li>Second item of the raw</li></ul>",
+					"<ul><li> list</li></ul>",
+				},
+				true,
+			},
+		},
+		"html_void_1": {
+			// self-closing and void tags are kept inline and never closed
+			&testInput{"Some text<br/>with a line break and an <img src=x/> image, <b>bold<br>and a rule</b><hr>\n", 30, ""},
+			&testOutput{
+				[]string{
+					"Some text<br/>",
+					"with a line break and an ",
+					"<img src=x/> image, ",
+					"<b>bold<br>and a rule</b><hr>",
+				},
+				true,
+			},
+		},
 		"tables_1": {
 			&testInput{
 				markdown: `
@@ -141,12 +205,35 @@ func TestMarkdownSplit(t *testing.T) {
 				max:  100,
 				join: "",
 			},
+			&testOutput{
+				// the header along with a row doesn't fit, so the table can't be split by rows
+				chunks: []string{
+					"\n| A     | B          | This one has a very long heading | D      | E       |\n|-------|------------|",
+					"----------------------------------|--------|---------|\n| Text  | Text       | More text             ",
+					"           | Whaaat | Heyyy   |\n| C     | asnmdnasnd | Foo                              | Pepito | o",
+					"wewoie |\n| iiiii | oooo       | Bar                              | a      | lhgkgk  |\n",
+				},
+				ok: false,
+			},
+		},
+		"tables_by_rows": {
+			&testInput{
+				markdown: `
+| A     | B          | This one has a very long heading | D      | E       |
+|-------|------------|----------------------------------|--------|---------|
+| Text  | Text       | More text                        | Whaaat | Heyyy   |
+| C     | asnmdnasnd | Foo                              | Pepito | owewoie |
+| iiiii | oooo       | Bar                              | a      | lhgkgk  |
+`,
+				max:  170,
+				join: "",
+			},
 			&testOutput{
 				chunks: []string{
-					"| A     | B          | This one has a very long heading | D      | E       |\n|-------|------------|-",
-					"---------------------------------|--------|---------|\n| Text  | Text       | More text              ",
-					"          | Whaaat | Heyyy   |\n| C     | asnmdnasnd | Foo                              | Pepito | ow",
-					"ewoie |\n| iiiii | oooo       | Bar                              | a      | lhgkgk  |",
+					"| A | B | This one has a very long heading | D | E |\n| --- | --- | --- | --- | --- |\n" +
+						"| Text | Text | More text | Whaaat | Heyyy |\n",
+					"| A | B | This one has a very long heading | D | E |\n| --- | --- | --- | --- | --- |\n" +
+						"| C | asnmdnasnd | Foo | Pepito | owewoie |\n| iiiii | oooo | Bar | a | lhgkgk |\n",
 				},
 				ok: true,
 			},
@@ -193,14 +280,36 @@ Some text to show that the reference links can follow later.
 					"[I'm a relative reference to a repository file](../blob/master/LICENSE)",
 					"[You can use numbers for reference-style link definitions](http://slashdot.org)",
 					"Or leave it empty and use the [link text itself](http://www.reddit.com).",
-					"URLs and URLs in angle brackets will automatically get turned into links.\nhttp://www.example.com or ",
-					"[http://www.example.com](http://www.example.com) and sometimes",
-					"\nexample.com (but not on Github, for example).",
+					"URLs and URLs in angle brackets will automatically get turned into links.\n",
+					"[http://www.example.com](http://www.example.com) or [http://www.example.com](http://www.example.com)",
+					" and sometimes\nexample.com (but not on Github, for example).",
 					"Some text to show that the reference links can follow later.",
 				},
 				true,
 			},
 		},
+		"images_1": {
+			&testInput{"Look at this ![a cute cat](https://example.com/cat.png \"Cat\") picture, it is great.\n", 60, ""},
+			&testOutput{
+				[]string{
+					"Look at this ",
+					"![a cute cat](https://example.com/cat.png \"Cat\")",
+					" picture, it is great.",
+				},
+				true,
+			},
+		},
+		"images_2": {
+			&testInput{"![A very long alternative text describing the picture in detail](https://example.com/cat.png)\n", 60, ""},
+			&testOutput{
+				[]string{
+					"![A very long alternative text](https://example.com/cat.png)",
+					"![ describing the picture in d](https://example.com/cat.png)",
+					"![etail](https://example.com/cat.png)",
+				},
+				true,
+			},
+		},
 		"lists_1": {
 			&testInput{`
 1. First ordered list item
@@ -238,7 +347,7 @@ Strikethrough uses two tildes. ~~Scratch this.~~
 					"Strong emphasis, aka bold, with ",
 					"**asterisks** or **underscores**.",
 					"Combined emphasis with ",
-					"**asterisks and ****_underscores_**.",
+					"**asterisks and _underscores_**.",
 					"Strikethrough uses two tildes. ",
 					"~~Scratch this.~~",
 				},
@@ -254,11 +363,2635 @@ Strikethrough uses two tildes. ~~Scratch this.~~
 			result, ok := MarkdownSplit(tc.input.markdown, tc.input.max, tc.input.join)
 			assert.Equal(t, tc.expected.chunks, result)
 			assert.Equal(t, tc.expected.ok, ok)
+			if ok {
+				assert.NoError(t, ValidateChunks(result))
+			}
 
 			for _, cm := range result {
 				correctLen := len(cm) <= tc.input.max
 				assert.Truef(t, correctLen, "length is higher than max (%d)", len(cm))
+
+				for _, void := range []string{"</br>", "</img>", "</hr>"} {
+					assert.NotContains(t, cm, void)
+				}
+			}
+
+			// the plan tells the same outcome
+			plan := Plan(tc.input.markdown, tc.input.max, tc.input.join)
+			assert.Equal(t, len(tc.expected.chunks), plan.ChunkCount)
+			assert.Equal(t, !tc.expected.ok, plan.WillFallback)
+			assert.Equal(t, plan.WillFallback, plan.Reason != SplitOK)
+			if tc.input.max > 0 {
+				assert.Equal(t, ok, plan.MinFeasibleMax <= tc.input.max)
+			}
+
+			// the split only sees the tree of the parser, so another one building the same tree splits the same
+			md := &countingParser{}
+			opts := DefaultOptions()
+			opts.parser = md
+
+			result, ok = MarkdownSplitOpts(tc.input.markdown, tc.input.max, tc.input.join, opts)
+			assert.Equal(t, tc.expected.chunks, result)
+			assert.Equal(t, tc.expected.ok, ok)
+			if len(tc.input.markdown) > tc.input.max {
+				assert.Equal(t, 1, md.calls)
+			}
+
+			// and the tree is walked the same way blackfriday walks its own
+			assert.Equal(t, blackfridayWalk(tc.input.markdown), treeWalk(tc.input.markdown))
+		})
+	}
+}
+
+// countingParser is the default parser, counting the times it's called to tell that the split goes through it.
+type countingParser struct {
+	blackfridayParser
+	calls int
+}
+
+func (p *countingParser) parse(text string, extensions blackfriday.Extensions) *mdNode {
+	p.calls++
+	return p.blackfridayParser.parse(text, extensions)
+}
+
+// blackfridayWalk returns the nodes blackfriday visits walking the text, as their types, the exits marked with a /.
+func blackfridayWalk(text string) []string {
+	var visits []string
+	blackfriday.New(blackfriday.WithExtensions(DefaultOptions().Extensions)).Parse([]byte(text)).Walk(
+		func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+			visit := fmt.Sprint(blackfridayTypes[node.Type])
+			if !entering {
+				visit = "/" + visit
+			}
+			visits = append(visits, visit+":"+string(node.Literal))
+
+			return blackfriday.GoToNext
+		})
+
+	return visits
+}
+
+// treeWalk returns the nodes walking the tree of the text visits, like blackfridayWalk.
+func treeWalk(text string) []string {
+	var visits []string
+	blackfridayParser{}.parse(text, DefaultOptions().Extensions).walk(func(node *mdNode, entering bool) walkStatus {
+		visit := fmt.Sprint(node.typ)
+		if !entering {
+			visit = "/" + visit
+		}
+		visits = append(visits, visit+":"+string(node.literal))
+
+		return goToNext
+	})
+
+	return visits
+}
+
+func TestSplitPlatforms(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		split func(text, sep string) ([]string, bool)
+		max   int
+	}{
+		"github_comment":    {SplitGithubComment, MaxGithubCommentSize},
+		"github_issue_body": {SplitGithubIssueBody, MaxGithubIssueBodySize},
+		"gitlab_comment":    {SplitGitlabComment, MaxGitlabCommentSize},
+		"jira_comment":      {SplitJiraComment, MaxJiraCommentSize},
+		"slack_message":     {SplitSlackMessage, MaxSlackMessageSize},
+		"discord_message":   {SplitDiscordMessage, MaxDiscordMessageSize},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			text := strings.Repeat("Some **words** here. ", tc.max/20)
+
+			result, ok := tc.split(text, "")
+			assert.True(t, ok)
+			assert.Len(t, result, 2)
+			assert.Greater(t, len(result[0]), tc.max-20)
+
+			for _, cm := range result {
+				assert.LessOrEqual(t, len(cm), tc.max)
+			}
+		})
+	}
+}
+
+func TestValidateChunk(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		chunk    string
+		expected error
+	}{
+		"valid":           {"**Bold** _italic_ ~~del~~ [link](x) `**code**` <b>html</b><br>", nil},
+		"prose":           {"snake_case and 5 * 3 and \\*\\* escaped", nil},
+		"code_block":      {"```go\n**x\n```\n", nil},
+		"unclosed_fence":  {"```go\nfunc main() {", ErrUnclosedCodeFence},
+		"unclosed_strong": {"Some **bold", ErrUnbalancedEmphasis},
+		"unclosed_emph":   {"Some _italic", ErrUnbalancedEmphasis},
+		"unopened_emph":   {"italic_ text", ErrUnbalancedEmphasis},
+		"unclosed_del":    {"Some ~~deleted", ErrUnbalancedEmphasis},
+		"dangling_link":   {"A [link](https://exam", ErrDanglingLink},
+		"dangling_image":  {"![image](https://exam", ErrDanglingLink},
+		"unclosed_html":   {"<div>Some text", ErrUnbalancedHTML},
+		"unopened_html":   {"Some text</div>", ErrUnbalancedHTML},
+		"mismatched_html": {"<div><span>x</div></span>", ErrUnbalancedHTML},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := ValidateChunk(tc.chunk)
+			if tc.expected == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.Truef(t, errors.Is(err, tc.expected), "%v", err)
+			}
+		})
+	}
+
+	err := ValidateChunks([]string{"Fine.", "Some **bold", "<div>x"})
+	assert.Truef(t, errors.Is(err, ErrUnbalancedEmphasis), "%v", err)
+	assert.Truef(t, errors.Is(err, ErrUnbalancedHTML), "%v", err)
+	assert.Contains(t, err.Error(), "chunk 1: ")
+	assert.Contains(t, err.Error(), "chunk 2: ")
+
+	assert.NoError(t, ValidateChunks([]string{"Fine.", "**Also** fine."}))
+}
+
+func TestSplitIntoN(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		markdown string
+		n        int
+		ok       bool
+	}{
+		"basic_2": {"Some basic comment", 2, true},
+		"basic_3": {"Some basic comment", 3, true},
+		// the title overhead makes it impossible to fit the content in only 2 chunks
+		"title_2":  {"### Comment with title\n\nIncludes the title in every split.", 2, false},
+		"title_3":  {"### Comment with title\n\nIncludes the title in every split.", 3, true},
+		"styles_2": {"Strong emphasis, aka bold, with **asterisks** or __underscores__.", 2, true},
+		"styles_3": {"Strong emphasis, aka bold, with **asterisks** or __underscores__.", 3, true},
+		"lists_2":  {"1. First ordered list item\n2. Another item\n3. And another item.\n", 2, false},
+		"lists_3":  {"1. First ordered list item\n2. Another item\n3. And another item.\n", 3, false},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("case_%s", name), func(t *testing.T) {
+			t.Parallel()
+			result, ok := SplitIntoN(tc.markdown, tc.n, "")
+			assert.Equal(t, tc.ok, ok)
+			assert.LessOrEqual(t, len(result), tc.n)
+			assert.Greater(t, len(result), 1)
+		})
+	}
+
+	result, ok := SplitIntoN("Some basic comment", 0, "")
+	assert.Nil(t, result)
+	assert.False(t, ok)
+}
+
+func TestMarkdownSplitRebalance(t *testing.T) {
+	t.Parallel()
+
+	text := "aaaaaaaaaa bbbbbbbbb c"
+
+	result, ok := MarkdownSplit(text, 10, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"aaaaaaaaaa", " bbbbbbbbb", " c"}, result)
+
+	opts := DefaultOptions()
+	opts.Rebalance = true
+
+	result, ok = MarkdownSplitOpts(text, 10, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"aaaaaaaa", "aa bbbbb", "bbbb c"}, result)
+
+	for _, cm := range result {
+		assert.LessOrEqual(t, len(cm), 10)
+		assert.GreaterOrEqual(t, len(cm)*4, 10)
+	}
+}
+
+func TestMarkdownSplitFrontMatter(t *testing.T) {
+	t.Parallel()
+
+	frontMatter := "---\ntitle: Hello\ntags: [a, b]\n---\n"
+	text := frontMatter + "\nSome basic comment"
+	styledText := frontMatter + "\nHi **there**, and a much longer comment that won't fit."
+
+	testCases := map[string]struct {
+		text     string
+		max      int
+		preserve bool
+		expected []string
+	}{
+		"attached": {
+			styledText,
+			50,
+			true,
+			[]string{frontMatter + "Hi **there**", ", and a much longer comment that won't fit."},
+		},
+		"own_chunk": {
+			text,
+			36,
+			true,
+			[]string{frontMatter, "Some basic comment"},
+		},
+		"disabled": {
+			text,
+			20,
+			false,
+			// the yaml gets parsed as a setext heading, breaking it
+			[]string{"\n\n---\n\ntitle: Hello", "## tags: [a, b]\n\n", "Some basic comment"},
+		},
+		"horizontal_rules": {
+			// a blank line after the delimiter makes it a horizontal rule rather than a front matter
+			"---\n\nSome text that is long enough to be split in two.\n\n---\n\nrest",
+			40,
+			true,
+			[]string{"\n\n---\n\n", "Some text that is long enough to be spli", "t in two.\n\n---\n\nrest"},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("case_%s", name), func(t *testing.T) {
+			t.Parallel()
+			opts := DefaultOptions()
+			opts.PreserveFrontMatter = tc.preserve
+
+			result, ok := MarkdownSplitOpts(tc.text, tc.max, "", opts)
+			assert.True(t, ok)
+			assert.Equal(t, tc.expected, result)
+
+			for _, cm := range result {
+				assert.LessOrEqual(t, len(cm), tc.max)
+			}
+		})
+	}
+
+	// front matter bigger than max can't be preserved
+	result, ok := MarkdownSplit(text, 20, "")
+	assert.False(t, ok)
+	assert.Equal(t, SimpleSplit(text, 20, ""), result)
+}
+
+func TestMarkdownSplitGraphemes(t *testing.T) {
+	t.Parallel()
+
+	family := "👨‍👩‍👧‍👦"
+	flag := "🇦🇷"
+	text := "ab" + family + "cd" + flag + "ef" + family
+
+	assert.Equal(t, 9, Graphemes.measure(text))
+
+	opts := DefaultOptions()
+	opts.LengthMode = Graphemes
+
+	result, ok := MarkdownSplitOpts(text, 3, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"ab" + family, "cd" + flag, "ef" + family}, result)
+
+	for _, cm := range result {
+		assert.LessOrEqual(t, Graphemes.measure(cm), 3)
+	}
+
+	// fits as a whole when measured in graphemes, but not in bytes
+	result, ok = MarkdownSplitOpts(text, 9, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{text}, result)
+
+	// bytes mode never cuts a rune, even if it breaks the emoji sequence
+	result, ok = MarkdownSplit(text, 10, "")
+	assert.True(t, ok)
+	for _, cm := range result {
+		assert.LessOrEqual(t, len(cm), 10)
+		assert.True(t, utf8.ValidString(cm))
+	}
+}
+
+func TestMarkdownSplitDisplayWidth(t *testing.T) {
+	t.Parallel()
+
+	text := "Hello 世界, こんにちは world"
+
+	assert.Equal(t, 28, DisplayWidth.measure(text))
+
+	opts := DefaultOptions()
+	opts.LengthMode = DisplayWidth
+
+	result, ok := MarkdownSplitOpts(text, 10, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"Hello 世界", ", こんにち", "は world"}, result)
+
+	for _, cm := range result {
+		assert.LessOrEqual(t, DisplayWidth.measure(cm), 10)
+	}
+}
+
+func TestMarkdownSplitExtensions(t *testing.T) {
+	t.Parallel()
+
+	text := `| Name  | Value |
+|-------|-------|
+| one   | 1     |
+| two   | 2     |
+| three | 3     |
+`
+
+	opts := DefaultOptions()
+	opts.Extensions = blackfriday.Tables
+
+	result, ok := MarkdownSplitOpts(text, 50, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"| Name | Value |\n| --- | --- |\n| one | 1 |\n",
+		"| Name | Value |\n| --- | --- |\n| two | 2 |\n",
+		"| Name | Value |\n| --- | --- |\n| three | 3 |\n",
+	}, result)
+
+	// without the extension, the table is just text
+	opts.Extensions = 0
+
+	result, ok = MarkdownSplitOpts(text, 50, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, SimpleSplit(strings.TrimSuffix(text, "\n"), 50, ""), result)
+}
+
+func TestMarkdownSplitReferenceLinks(t *testing.T) {
+	t.Parallel()
+
+	text := `See [the docs][docs] for details, and also read [Example] carefully, please.
+
+[docs]: https://example.com/docs "The docs"
+[example]: https://example.com
+`
+
+	testCases := map[string]struct {
+		inline   bool
+		expected []string
+	}{
+		"inline": {
+			true,
+			[]string{
+				"See [the docs](https://example.com/docs \"The docs\")",
+				" for details, and also read [Example](https://example.com)",
+				" carefully, please.",
+			},
+		},
+		"reference": {
+			false,
+			[]string{
+				"See [the docs][docs]\n\n[docs]: https://example.com/docs \"The docs\"",
+				" for details, and also read [Example]\n\n[example]: https://example.com",
+				" carefully, please.",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("case_%s", name), func(t *testing.T) {
+			t.Parallel()
+			opts := DefaultOptions()
+			opts.InlineReferenceLinks = tc.inline
+
+			result, ok := MarkdownSplitOpts(text, 70, "", opts)
+			assert.True(t, ok)
+			assert.Equal(t, tc.expected, result)
+
+			for _, cm := range result {
+				assert.LessOrEqual(t, len(cm), 70)
 			}
 		})
 	}
 }
+
+func TestMarkdownSplitLinkedImages(t *testing.T) {
+	t.Parallel()
+
+	// the image is kept whole inside the link when it fits in a chunk
+	result, ok := MarkdownSplit("Intro text before that, longer [![alt text](img.png)](http://x.com) and more text after.\n", 40, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Intro text before that, longer ",
+		"[![alt text](img.png)](http://x.com)",
+		" and more text after.",
+	}, result)
+
+	// otherwise, both of them are reopened in every chunk, nested as they were
+	result, ok = MarkdownSplit("Intro text before that, longer [![alt text that is long enough](img.png)](http://x.com) and more.\n", 45, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Intro text before that, longer ",
+		"[![alt text that is ](img.png)](http://x.com)",
+		"[![long enough](img.png)](http://x.com)",
+		" and more.",
+	}, result)
+}
+
+func TestMarkdownSplitLinkTitles(t *testing.T) {
+	t.Parallel()
+
+	text := "See [the site][ref] for more about it, and more and more.\n\n[ref]: https://example.com 'He said \"hi\"'\n"
+
+	result, ok := MarkdownSplit(text, 50, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"See ",
+		"[the site](https://example.com \"He said \\\"hi\\\"\")",
+		" for more about it, and more and more.",
+	}, result)
+
+	// the title is parsed back the same, apart from the escapes the parser keeps
+	var title string
+	blackfriday.New().Parse([]byte(result[1])).Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if node.Type == blackfriday.Link {
+			title = string(node.LinkData.Title)
+		}
+
+		return blackfriday.GoToNext
+	})
+	assert.Equal(t, `He said \"hi\"`, title)
+
+	// the quotes already escaped are kept as they are, unlike the ones after an escaped backslash
+	assert.Equal(t, `a \"b\" \\\"c\"`, escapeLinkTitle(`a \"b" \\"c\"`))
+}
+
+func TestMarkdownSplitUnresolvedReferences(t *testing.T) {
+	t.Parallel()
+
+	// there's no definition for the label, so the link is kept as it was, and not cut at ][
+	text := "See [the text][missing] for more about it.\n"
+
+	result, ok := MarkdownSplit(text, 20, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"See ",
+		"[the text][missing]",
+		" for more about it.",
+	}, result)
+
+	// it's cut like the rest of the text when it doesn't fit in a chunk
+	result, ok = MarkdownSplit(text, 12, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"See [the tex", "t][missing] ", "for more abo", "ut it."}, result)
+}
+
+func TestMarkdownSplitWikiLinks(t *testing.T) {
+	t.Parallel()
+
+	text := "See [[Some Page]] and [[Other Page|the alias]] for *more* [[x]].\n"
+
+	opts := DefaultOptions()
+	opts.WikiLinks = true
+
+	result, ok := MarkdownSplitOpts(text, 30, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"See [[Some Page]] and ",
+		"[[Other Page|the alias]] for ",
+		"_more_ [[x]].",
+	}, result)
+
+	// they are plain text otherwise
+	result, ok = MarkdownSplit(text, 30, "")
+	assert.True(t, ok)
+	assert.Equal(t, "See [[Some Page]] and [[Other ", result[0])
+}
+
+func TestMarkdownSplitFootnotes(t *testing.T) {
+	t.Parallel()
+
+	text := `First claim[^1] is here and then **second claim**[^note] follows with more text.
+
+[^1]: The first note.
+[^note]: The second note.
+`
+
+	testCases := map[string]struct {
+		max      int
+		expected []string
+	}{
+		"attached": {
+			50,
+			[]string{
+				"First claim[^1]\n\n[^1]: The first note.",
+				" is here and then ",
+				"**second claim[^note]**\n\n[^note]: The second note.",
+				" follows with more text.",
+			},
+		},
+		"moves_last_word": {
+			45,
+			[]string{
+				"First claim[^1]\n\n[^1]: The first note.",
+				" is here and then **second** ",
+				"**claim[^note]**\n\n[^note]: The second note.",
+				" follows with more text.",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("case_%s", name), func(t *testing.T) {
+			t.Parallel()
+			result, ok := MarkdownSplit(text, tc.max, "")
+			assert.True(t, ok)
+			assert.Equal(t, tc.expected, result)
+
+			for _, cm := range result {
+				assert.LessOrEqual(t, len(cm), tc.max)
+			}
+		})
+	}
+}
+
+func TestMarkdownSplitTaskLists(t *testing.T) {
+	t.Parallel()
+
+	text := `- [ ] write the code for the feature
+- [x] review **the** design
+  - [ ] nested task item
+- plain item
+`
+
+	opts := DefaultOptions()
+	opts.SplitLists = true
+
+	result, ok := MarkdownSplitOpts(text, 30, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"- [ ] write the code for the \n",
+		"- [ ] feature\n- [x] review \n",
+		"- [x] **the** design\n",
+		"  - [ ] nested task item\n",
+		"- plain item\n",
+	}, result)
+
+	for _, cm := range result {
+		assert.LessOrEqual(t, len(cm), 30)
+	}
+
+	// the blank line after the list keeps the next block out of its last item
+	result, ok = MarkdownSplitOpts("- one\n- two\n\nA paragraph after the list.\n\n# Heading\n\nMore", 40, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"- one\n- two\n\nA paragraph after the list.", "# Heading\n\nMore"}, result)
+
+	// lists fallback to simple split unless enabled
+	result, ok = MarkdownSplit(text, 30, "")
+	assert.False(t, ok)
+	assert.Equal(t, SimpleSplit(text, 30, ""), result)
+}
+
+func TestMarkdownSplitListStart(t *testing.T) {
+	t.Parallel()
+
+	text := `3. First step with some text
+4. Second step that is long enough
+5. Third one
+
+Then:
+
+` + "```" + `
+1. not an item
+` + "```" + `
+`
+
+	opts := DefaultOptions()
+	opts.SplitLists = true
+
+	result, ok := MarkdownSplitOpts(text, 40, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"3. First step with some text\n",
+		"4. Second step that is long enough\n",
+		"5. Third one\n\nThen:",
+		"```\n1. not an item\n```\n",
+	}, result)
+
+	// a line that looks like an item but isn't one makes them impossible to match, so they are numbered from 1
+	result, ok = MarkdownSplitOpts("Paragraph\n2019. was a year\n\n"+text, 40, "", opts)
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(result[1], "1. First"), result[1])
+}
+
+func TestMarkdownSplitListCodeBlocks(t *testing.T) {
+	t.Parallel()
+
+	fence := "```"
+	text := "1. Install it:\n\n    " + fence + "sh\n    go get example.com/tool\n    go install example.com/tool@latest\n" +
+		"    tool --version\n    " + fence + "\n\n2. Run it.\n"
+
+	opts := DefaultOptions()
+	opts.SplitLists = true
+
+	// the fence goes right after the marker of the item opened again, padded up to the indentation of the code
+	result, ok := MarkdownSplitOpts(text, 80, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"1. Install it:\n\n    " + fence + "sh\n    go get example.com/tool\n    " + fence + "\n\n",
+		"1.  " + fence + "sh\n    go install example.com/tool@latest\n    tool --version\n    " + fence + "\n\n",
+		"   2. Run it.\n",
+	}, result)
+
+	for _, cm := range result {
+		assert.NoError(t, ValidateChunk(cm))
+	}
+}
+
+func TestMarkdownSplitListParagraphs(t *testing.T) {
+	t.Parallel()
+
+	text := `- The first item, with a paragraph.
+
+    And a second one,
+    on two lines.
+
+- The second item, with a paragraph.
+
+    And a second one that is longer.
+`
+
+	opts := DefaultOptions()
+	opts.SplitLists = true
+
+	result, ok := MarkdownSplitOpts(text, 90, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"- The first item, with a paragraph.\n\n    And a second one,\n    on two lines.\n",
+		"- The second item, with a paragraph.\n\n    And a second one that is longer.\n",
+	}, result)
+
+	// the paragraphs beginning a chunk go in the item opened again
+	result, ok = MarkdownSplitOpts(text, 70, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"- The first item, with a paragraph.\n\n    And a second one,\n",
+		"- on two lines.\n- The second item, with a paragraph.\n",
+		"- And a second one that is longer.\n",
+	}, result)
+}
+
+func TestMarkdownSplitHorizontalRules(t *testing.T) {
+	t.Parallel()
+
+	text := "Section one text.\n\n---\n\nSection two text.\n\n***\n\nSection three.\n"
+
+	testCases := map[string]struct {
+		preferRuleBreaks bool
+		expected         []string
+	}{
+		"default": {
+			false,
+			[]string{
+				"Section one text.\n\n---\n\nSection two text.",
+				"\n\n---\n\nSection three.",
+			},
+		},
+		"prefer_rule_breaks": {
+			true,
+			[]string{
+				"Section one text.\n\n---\n\n",
+				"Section two text.\n\n---\n\n",
+				"Section three.",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("case_%s", name), func(t *testing.T) {
+			t.Parallel()
+			opts := DefaultOptions()
+			opts.PreferRuleBreaks = tc.preferRuleBreaks
+
+			result, ok := MarkdownSplitOpts(text, 45, "", opts)
+			assert.True(t, ok)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func BenchmarkChunksAsStr(b *testing.B) {
+	// a wrapper-heavy input: every chunk is nested in several wrappers
+	var wrappers []*wrapper
+	for i := 0; i < 20; i++ {
+		wrappers = append(wrappers, &wrapper{begin: fmt.Sprintf("<tag%d>", i), end: fmt.Sprintf("</tag%d>", i)})
+	}
+
+	var chunks []*chunk
+	for i := 0; i < 2000; i++ {
+		chunks = append(chunks, &chunk{content: strings.Repeat("x", 20), wrappers: []*wrapper{
+			{begin: "**", end: "**"},
+			{begin: "_", end: "_"},
+		}})
+		chunks[i].wrappers = append(chunks[i].wrappers, wrappers...)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		chunksAsStr(chunks, MaxGithubCommentSize, "", nil, 0, Bytes)
+	}
+}
+
+func BenchmarkChunksAsStrMerge(b *testing.B) {
+	// thousands of tiny chunks that all get merged into a few big ones
+	var chunks []*chunk
+	for i := 0; i < 20000; i++ {
+		chunks = append(chunks, &chunk{content: "tiny "})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		chunksAsStr(chunks, MaxGithubCommentSize, "", nil, 0, Bytes)
+	}
+}
+
+func BenchmarkMarkdownSplit(b *testing.B) {
+	// documents of about 64KB, like the longest GitHub comments
+	repeat := func(s string) string {
+		return strings.Repeat(s, MaxGithubCommentSize/len(s))
+	}
+
+	docs := []struct {
+		name string
+		text string
+	}{
+		{"prose", "# Title\n\n" + repeat("Some **bold** and _italic_ words, with a [link](http://example.com) in between.\n\n")},
+		{"code", repeat("```go\nfunc main() {\n\tfmt.Println(\"hello, world\")\n}\n```\n\nA line of text.\n\n")},
+		{"wrappers", repeat("<div><section><article><p>Some <b>nested</b> text, <i>deeply</i> so.</p></article></section></div>\n\n")},
+	}
+
+	for _, doc := range docs {
+		b.Run(doc.name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				MarkdownSplit(doc.text, MaxDiscordMessageSize, "")
+			}
+		})
+	}
+}
+
+func TestMarkdownSplitBreadcrumbTitles(t *testing.T) {
+	t.Parallel()
+
+	text := `# Chapter
+
+Intro text for the chapter.
+
+## Section A
+
+Text of section A.
+
+### Detail
+
+More.
+
+## Section B
+
+Text of section B.
+`
+
+	opts := DefaultOptions()
+	opts.BreadcrumbTitles = true
+
+	result, ok := MarkdownSplitOpts(text, 70, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"# Chapter (1/4)\n\nIntro text for the chapter.",
+		"# Chapter > ## Section A (2/4)\n\nText of section A.",
+		"# Chapter > ## Section A > ### Detail (3/4)\n\nMore.",
+		"# Chapter > ## Section B (4/4)\n\nText of section B.",
+	}, result)
+
+	for _, cm := range result {
+		assert.LessOrEqual(t, len(cm), 70)
+	}
+}
+
+func TestMarkdownSplitNumberTitlesPerSection(t *testing.T) {
+	t.Parallel()
+
+	text := `# Section A
+
+Some text of the first section, long enough for a few chunks.
+
+## Sub
+
+More.
+
+# Section B
+
+Some text of the second section, shorter.
+`
+
+	opts := DefaultOptions()
+	opts.BreadcrumbTitles = true
+	opts.NumberTitlesPerSection = true
+
+	result, ok := MarkdownSplitOpts(text, 45, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"# Section A (1/6)\n\nSome text of t",
+		"# Section A (2/6)\n\nhe first secti",
+		"# Section A (3/6)\n\non, long enoug",
+		"# Section A (4/6)\n\nh for a few ch",
+		"# Section A (5/6)\n\nunks.",
+		"# Section A > ## Sub (6/6)\n\nMore.",
+		"# Section B (1/3)\n\nSome text of t",
+		"# Section B (2/3)\n\nhe second sect",
+		"# Section B (3/3)\n\nion, shorter.",
+	}, result)
+
+	for _, cm := range result {
+		assert.LessOrEqual(t, len(cm), 45)
+	}
+}
+
+func TestMarkdownSplitTitleRenderer(t *testing.T) {
+	t.Parallel()
+
+	text := "# Title\n\nSome text that is long enough to be split in a few chunks.\n"
+
+	opts := DefaultOptions()
+	opts.TitleRenderer = func(base string, index, total int) string {
+		return fmt.Sprintf("%s [%02d/%02d]", base, index, total)
+	}
+
+	result, ok := MarkdownSplitOpts(text, 40, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"# Title [01/05]\n\nSome text tha",
+		"# Title [02/05]\n\nt is long eno",
+		"# Title [03/05]\n\nugh to be spl",
+		"# Title [04/05]\n\nit in a few c",
+		"# Title [05/05]\n\nhunks.",
+	}, result)
+}
+
+func TestMarkdownSplitAtHeadings(t *testing.T) {
+	t.Parallel()
+
+	text := `Intro paragraph.
+
+## Section A
+
+Text A.
+
+## Section B
+
+Text of section B.
+
+### Detail
+
+More.
+
+## Section C
+
+Text C.
+`
+
+	opts := DefaultOptions()
+	opts.SplitAtHeadings = 2
+
+	result, ok := MarkdownSplitOpts(text, 100, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Intro paragraph.",
+		"## Section A\n\nText A.",
+		"## Section B\n\nText of section B.### Detail\n\nMore.",
+		"## Section C\n\nText C.",
+	}, result)
+}
+
+func TestMarkdownSplitAutolinks(t *testing.T) {
+	t.Parallel()
+
+	text := "Visit <http://www.example.com/a/long/path> for more, or mail <someone@example.com> or see http://foo.com ok."
+
+	opts := DefaultOptions()
+	opts.PreserveAutolinks = true
+
+	result, ok := MarkdownSplitOpts(text, 40, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Visit ",
+		"<http://www.example.com/a/long/path>",
+		" for more, or mail <someone@example.com>",
+		" or see [http://foo.com](http://foo.com)",
+		" ok.",
+	}, result)
+
+	// the url can't fit whole in a chunk
+	_, ok = MarkdownSplitOpts(text, 30, "", opts)
+	assert.False(t, ok)
+}
+
+func TestMarkdownSplitHardbreaks(t *testing.T) {
+	t.Parallel()
+
+	text := "Roses are red,  \nviolets are blue,  \nsugar is sweet,  \nand so are you.\n"
+
+	result, ok := MarkdownSplit(text, 40, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Roses are red,  \nviolets are blue,  \n",
+		"sugar is sweet,  \nand so are you.",
+	}, result)
+
+	opts := DefaultOptions()
+	opts.Extensions |= blackfriday.BackslashLineBreak
+
+	result, ok = MarkdownSplitOpts(strings.ReplaceAll(text, "  \n", "\\\n"), 40, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Roses are red,  \nviolets are blue,  \n",
+		"sugar is sweet,  \nand so are you.",
+	}, result)
+}
+
+func TestMarkdownSplitSoftBreaks(t *testing.T) {
+	t.Parallel()
+
+	// the parser keeps the line breaks of a paragraph in its text, so the lines wrapped by hand stay as they were
+	text := "This paragraph is wrapped by hand\nat some width, so it reads well\nas plain text in the terminal.\n\n> And a quote wrapped\n> by hand as well.\n"
+
+	result, ok := MarkdownSplit(text, 40, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"This paragraph is wrapped by hand\nat som",
+		"e width, so it reads well\nas plain text ",
+		"in the terminal.\n\n",
+		"> And a quote wrapped\n\n",
+		"> by hand as well.\n\n",
+	}, result)
+}
+
+func TestMarkdownSplitMath(t *testing.T) {
+	t.Parallel()
+
+	opts := DefaultOptions()
+	opts.Math = true
+
+	text := "The energy is $E = mc^2$ where $m_1 * m_2$ holds, costs $5 and $10."
+
+	result, ok := MarkdownSplitOpts(text, 40, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"The energy is $E = mc^2$ where ",
+		"$m_1 * m_2$ holds, costs $5 and $10.",
+	}, result)
+
+	text = `$$
+\sum_{i=1}^{n} x_i = x_1 + x_2 + x_3
+\int_0^1 f(x) dx = F(1) - F(0)
+$$
+`
+
+	result, ok = MarkdownSplitOpts(text, 50, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"$$\n\\sum_{i=1}^{n} x_i = x_1 + x_2 + x_3\n$$\n",
+		"$$\n\\int_0^1 f(x) dx = F(1) - F(0)\n$$\n",
+	}, result)
+
+	// the anchor of the placeholders is always the same, unless the text has it
+	anchor := genTextAnchor("")
+	assert.Equal(t, anchor, genTextAnchor(""))
+	assert.NotEqual(t, anchor, genTextAnchor(anchor))
+
+	text = strings.Trim(anchor, "<>") + "0M is not $x$, which is long enough to split."
+
+	result, ok = MarkdownSplitOpts(text, 45, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, text, strings.Join(result, ""))
+}
+
+func TestMarkdownSplitTableAlignment(t *testing.T) {
+	t.Parallel()
+
+	text := `| Left | Center | Right |
+|:-----|:------:|------:|
+| a | b | c |
+| dd | ee | ff |
+| ggg | hhh | iii |
+`
+
+	result, ok := MarkdownSplit(text, 90, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"| Left | Center | Right |\n| :--- | :---: | ---: |\n| a | b | c |\n| dd | ee | ff |\n",
+		"| Left | Center | Right |\n| :--- | :---: | ---: |\n| ggg | hhh | iii |\n",
+	}, result)
+}
+
+func TestMarkdownSplitTableEscapedPipes(t *testing.T) {
+	t.Parallel()
+
+	text := "| A | B |\n|---|---|\n| a \\| b | c |\n| long cell here | **other \\| one** `x \\| y` |\n"
+
+	result, ok := MarkdownSplit(text, 80, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"| A | B |\n| --- | --- |\n| a \\| b | c |\n",
+		"| A | B |\n| --- | --- |\n| long cell here | **other \\| one** `x \\| y` |\n",
+	}, result)
+
+	// every row still has two cells
+	for _, cm := range result {
+		blackfriday.New(blackfriday.WithExtensions(blackfriday.Tables)).Parse([]byte(cm)).Walk(
+			func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+				if node.Type == blackfriday.TableRow && entering {
+					cells := 0
+					for cell := node.FirstChild; cell != nil; cell = cell.Next {
+						cells++
+					}
+					assert.Equal(t, 2, cells, cm)
+				}
+
+				return blackfriday.GoToNext
+			})
+	}
+}
+
+func TestMarkdownSplitTildeFences(t *testing.T) {
+	t.Parallel()
+
+	text := "~~~go\nfmt.Println(\"```\")\nfmt.Println(\"done\")\n~~~\n"
+
+	result, ok := MarkdownSplit(text, 40, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"~~~go\nfmt.Println(\"```\")\n~~~\n",
+		"~~~go\nfmt.Println(\"done\")\n~~~\n",
+	}, result)
+}
+
+func TestMarkdownSplitFenceLength(t *testing.T) {
+	t.Parallel()
+
+	text := "    ```go\n    fmt.Println(\"hi\")\n    ```\n"
+
+	result, ok := MarkdownSplit(text, 30, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"````\n```go\n````\n",
+		"````\nfmt.Println(\"hi\")\n````\n",
+		"````\n```\n````\n",
+	}, result)
+}
+
+func TestMarkdownSplitCodeBlocks(t *testing.T) {
+	t.Parallel()
+
+	text := "Some prose before the code.\n\n```go\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n```\n\n" +
+		"Some prose between blocks.\n\n```sh\necho hello\nls -la /tmp\n```\n\nAnd after.\n"
+
+	result, ok := MarkdownSplit(text, 30, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Some prose before the code.",
+		"```go\nfunc main() {\n```\n",
+		"```go\n\tfmt.Println(\"hi\")\n```\n",
+		"```go\n}\n```\n",
+		"Some prose between blocks.",
+		"```sh\necho hello\n```\n",
+		"```sh\nls -la /tmp\n```\n",
+		"And after.",
+	}, result)
+
+	// the fences begin a line of their own when the blocks are merged with the prose
+	result, ok = MarkdownSplit(text, 80, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Some prose before the code.\n\n```go\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n```\n",
+		"Some prose between blocks.\n\n```sh\necho hello\nls -la /tmp\n```\nAnd after.",
+	}, result)
+
+	// every code block gets a joint, right after another block or in a blockquote too
+	text = "```go\na := 1\n```\n\n```sh\necho b\n```\n\n> quoted\n>\n> ```\n> c\n> ```\n"
+
+	result, ok = MarkdownSplit(text, 45, "")
+	assert.True(t, ok)
+	assert.Len(t, result, 2)
+	assert.True(t, strings.HasPrefix(result[0], "```go\na := 1\n```\n\n```sh\necho b\n```\n"), result[0])
+	assert.True(t, strings.HasPrefix(result[1], "> quoted\n>\n> ```\n> c\n> ```\n"), result[1])
+}
+
+func TestMarkdownSplitLossless(t *testing.T) {
+	t.Parallel()
+
+	corpus := []string{
+		"Some basic comment that is long enough to be split in several chunks.",
+		"  Leading whitespace and a first paragraph.\n\nA second one after a blank line.\n",
+		"Several blank lines\n\n\n\nbetween paragraphs, and trailing ones.\n\n\n",
+		"A soft break\n   with an indented continuation line, and a hard one  \nright here.",
+		"Escaped \\*asterisks\\* are kept escaped, and so is a\\\nbackslash break.",
+		"Unicode text: héllo wörld, 你好世界, and some more words to split.\n",
+	}
+
+	opts := DefaultOptions()
+	opts.Lossless = true
+	opts.Extensions |= blackfriday.BackslashLineBreak
+
+	for _, text := range corpus {
+		for max := 20; max <= len(text); max += 7 {
+			result, ok := MarkdownSplitOpts(text, max, "", opts)
+			assert.True(t, ok, "text %q with max %d", text, max)
+			assert.Equal(t, text, strings.Join(result, ""), "text %q with max %d", text, max)
+
+			for _, cm := range result {
+				assert.LessOrEqual(t, len(cm), max)
+			}
+		}
+	}
+}
+
+func TestMarkdownSplitDeterministicTitles(t *testing.T) {
+	t.Parallel()
+
+	text := "# Title\n\n" + strings.Repeat("Some words to fill the chunks. ", 12)
+
+	result, ok := MarkdownSplit(text, 40, "")
+	assert.True(t, ok)
+	assert.Len(t, result, 29)
+
+	for i, cm := range result {
+		assert.True(t, strings.HasPrefix(cm, fmt.Sprintf("# Title (%d/29)\n\n", i+1)), cm)
+		assert.LessOrEqual(t, len(cm), 40)
+	}
+
+	again, _ := MarkdownSplit(text, 40, "")
+	assert.Equal(t, result, again)
+}
+
+func TestMarkdownSplitLongTitle(t *testing.T) {
+	t.Parallel()
+
+	// the title takes most of every chunk, leaving little room for the text, or none at all
+	text := "# A heading long enough to take most of a chunk\n\n" + strings.Repeat("Some words after it. ", 6)
+
+	for max := 1; max <= 120; max++ {
+		result, ok := MarkdownSplit(text, max, " ...")
+		for _, cm := range result {
+			assert.LessOrEqual(t, len(cm), max, "max %d, ok %v: %q", max, ok, cm)
+		}
+	}
+
+	result, ok := MarkdownSplit(text, 80, "")
+	assert.True(t, ok)
+	for i, cm := range result {
+		assert.True(t, strings.HasPrefix(cm, fmt.Sprintf("# A heading long enough to take most of a chunk (%d/%d)", i+1, len(result))), cm)
+	}
+}
+
+func TestSplitDetailed(t *testing.T) {
+	t.Parallel()
+
+	// lists aren't split by default, so it falls back to a simple split
+	text := "1. First ordered list item\n2. Another item\n3. And another item.\n"
+
+	result := SplitDetailed(text, 20, "~", DefaultOptions())
+	assert.True(t, result.Fallback)
+	assert.Equal(t, ReasonListsNotSplit.String(), result.Reason)
+	assert.Equal(t, ReasonListsNotSplit, result.ReasonCode)
+	assert.Len(t, result.ByteRanges, len(result.Chunks))
+
+	var rebuilt strings.Builder
+	for i, r := range result.ByteRanges {
+		rebuilt.WriteString(text[r[0]:r[1]])
+		assert.True(t, strings.HasPrefix(result.Chunks[i], text[r[0]:r[1]]))
+	}
+	assert.Equal(t, text, rebuilt.String())
+
+	text = "# Title\n\nSome text that is long enough to be split in a few chunks."
+
+	result = SplitDetailed(text, 40, "", DefaultOptions())
+	assert.False(t, result.Fallback)
+	assert.Empty(t, result.Reason)
+	assert.Len(t, result.ByteRanges, len(result.Chunks))
+	assert.Equal(t, 0, result.ByteRanges[0][0])
+	assert.Equal(t, len(text), result.ByteRanges[len(result.ByteRanges)-1][1])
+
+	for i := 1; i < len(result.ByteRanges); i++ {
+		assert.Equal(t, result.ByteRanges[i-1][1], result.ByteRanges[i][0])
+	}
+}
+
+func TestSplitWithOffsets(t *testing.T) {
+	t.Parallel()
+
+	// lists aren't split by default, so the offsets are the ones of the simple split
+	text := "1. First ordered list item\n2. Another item\n3. And another item.\n"
+
+	chunks, ranges, ok := SplitWithOffsets(text, 20, "")
+	assert.False(t, ok)
+	assert.Len(t, ranges, len(chunks))
+
+	for i, r := range ranges {
+		assert.Equal(t, chunks[i], text[r.Start:r.End])
+	}
+
+	text = "# Title\n\nSome text that is **long enough** to be split in a few chunks.\n\nAnd another paragraph."
+
+	chunks, ranges, ok = SplitWithOffsets(text, 40, "")
+	assert.True(t, ok)
+	assert.Len(t, ranges, len(chunks))
+	assert.Greater(t, len(ranges), 1)
+
+	for i, r := range ranges {
+		assert.LessOrEqual(t, r.Start, r.End)
+		if i > 0 {
+			assert.LessOrEqual(t, ranges[i-1].End, r.Start)
+		}
+	}
+	assert.Equal(t, len(text), ranges[len(ranges)-1].End)
+
+	// the words of every chunk are within its range, apart from the ones at its edges, which may be cut
+	for _, text := range []string{
+		"first line\nsecond line\nthird line here\nfourth",
+		"Some text that is **long enough** to be split in a few chunks.\n\nAnd another paragraph.",
+	} {
+		chunks, ranges, ok = SplitWithOffsets(text, 20, "")
+		assert.True(t, ok)
+		assert.Len(t, ranges, len(chunks))
+
+		for i, r := range ranges {
+			if words := wordRe.FindAllString(chunks[i], -1); len(words) > 2 {
+				for _, word := range words[1 : len(words)-1] {
+					assert.Contains(t, text[r.Start:r.End], word, chunks[i])
+				}
+			}
+		}
+	}
+
+	// the offsets are in the text as given, before its line endings are normalized
+	text = "1. First ordered list item\r\n2. Another item\r\n3. And another item.\r\n"
+
+	chunks, ranges, ok = SplitWithOffsets(text, 20, "")
+	assert.False(t, ok)
+	assert.Len(t, ranges, len(chunks))
+	assert.Equal(t, len(text), ranges[len(ranges)-1].End)
+
+	for i, r := range ranges {
+		assert.Equal(t, chunks[i], strings.ReplaceAll(text[r.Start:r.End], "\r\n", "\n"))
+	}
+}
+
+func TestMarkdownSplitFunc(t *testing.T) {
+	t.Parallel()
+
+	text := "### Comment with title\n\nIncludes the title in every split."
+	expected, _ := MarkdownSplit(text, 40, "")
+
+	var chunks []string
+	err := MarkdownSplitFunc(text, 40, "", func(index, total int, chunk string) error {
+		assert.Equal(t, len(chunks), index)
+		assert.Equal(t, len(expected), total)
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, chunks)
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = MarkdownSplitFunc(text, 40, "", func(index, total int, chunk string) error {
+		calls++
+		return errStop
+	})
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, 1, calls)
+
+	// the chunks are the same whether they are emitted as they are assembled or not
+	for _, tc := range []struct {
+		text string
+		max  int
+		sep  string
+	}{
+		{"---\ntitle: Hello\n---\n\nHi **there**, and a much longer comment that won't fit.", 50, ""},
+		{"---\ntitle: Hello\n---\n\nSome basic comment", 36, ""},
+		{"# Title\n\n" + strings.Repeat("Some **bold** words. ", 6), 45, " ..."},
+		{"- a list\n- that isn't split\n- by default", 20, ""},
+		{"Short.", 20, ""},
+	} {
+		expected, _ := MarkdownSplit(tc.text, tc.max, tc.sep)
+
+		var chunks []string
+		err := MarkdownSplitFunc(tc.text, tc.max, tc.sep, func(index, total int, chunk string) error {
+			assert.Equal(t, len(chunks), index)
+			assert.Equal(t, len(expected), total)
+			chunks = append(chunks, chunk)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, chunks, tc.text)
+	}
+}
+
+func TestMarkdownSplitMaxChunks(t *testing.T) {
+	t.Parallel()
+
+	text := "# Title\n\n" + strings.Repeat("Some words. ", 8)
+
+	all, ok := MarkdownSplit(text, 40, "")
+	assert.True(t, ok)
+	assert.Len(t, all, 8)
+
+	opts := DefaultOptions()
+	opts.MaxChunks = 5
+
+	result := SplitDetailed(text, 40, "", opts)
+	assert.Equal(t, ErrTooManyChunks, result.Err)
+	assert.Len(t, result.Chunks, 5)
+	assert.Equal(t, "# Title (1/5)\n\nSome words. S", result.Chunks[0])
+
+	chunks, ok := MarkdownSplitOpts(text, 40, "", opts)
+	assert.False(t, ok)
+	assert.Len(t, chunks, 5)
+
+	opts.MaxChunks = 8
+	chunks, ok = MarkdownSplitOpts(text, 40, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, all, chunks)
+}
+
+func TestMarkdownSplitMinChunkSize(t *testing.T) {
+	t.Parallel()
+
+	text := "First part of text.\n\n---\n\nSecond part.\n\n---\n\nDone.\n"
+
+	opts := DefaultOptions()
+	opts.PreferRuleBreaks = true
+
+	result, ok := MarkdownSplitOpts(text, 40, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"First part of text.\n\n---\n\n",
+		"Second part.\n\n---\n\n",
+		"Done.",
+	}, result)
+
+	opts.MinChunkSize = 10
+
+	result, ok = MarkdownSplitOpts(text, 40, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"First part of text.\n\n---\n\n",
+		"Second part.\n\n---\n\nDone.",
+	}, result)
+}
+
+func TestMarkdownSplitTrimChunks(t *testing.T) {
+	t.Parallel()
+
+	opts := DefaultOptions()
+
+	result, ok := MarkdownSplitOpts("Some basic comment", 10, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"Some basic", " comment"}, result)
+
+	opts.TrimChunks = true
+
+	result, ok = MarkdownSplitOpts("Some basic comment", 10, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"Some basic", "comment"}, result)
+
+	texts := []string{
+		"\n1. First ordered list item\n2. Another item\n3. And another item.\n",
+		"First part of text.\n\n---\n\nSecond part.\n\n---\n\nDone.\n",
+		"Roses are red,  \nviolets are blue,  \nsugar is sweet,  \nand so are you.\n",
+	}
+
+	for _, text := range texts {
+		result, _ = MarkdownSplitOpts(text, 20, "", opts)
+		for _, cm := range result {
+			assert.Equal(t, strings.TrimSpace(cm), cm)
+			assert.NotEmpty(t, cm)
+		}
+	}
+}
+
+func TestMarkdownSplitSeparator(t *testing.T) {
+	t.Parallel()
+
+	sep := "\n(cont.)"
+	text := "### Comment with title\n\nIncludes **the title** in every split."
+
+	result, ok := MarkdownSplit(text, 55, sep)
+	assert.True(t, ok)
+	assert.Greater(t, len(result), 1)
+
+	for i, cm := range result {
+		assert.LessOrEqual(t, len(cm), 55)
+		assert.Equal(t, i < len(result)-1, strings.HasSuffix(cm, sep), cm)
+	}
+}
+
+func TestMarkdownSplitWrappersAtMax(t *testing.T) {
+	t.Parallel()
+
+	// the link takes all but one character of max, so its text is split one character at a time
+	result, ok := MarkdownSplit("[abc](https://x.io)", 17, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"[a](https://x.io)", "[b](https://x.io)", "[c](https://x.io)"}, result)
+
+	// there's no room left for the text
+	result, ok = MarkdownSplit("[abc](https://x.io)", 16, "")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"[abc](https://x.", "io)"}, result)
+}
+
+func TestSplitEmptyText(t *testing.T) {
+	t.Parallel()
+
+	for _, text := range []string{"", "\n", "     "} {
+		result, ok := MarkdownSplit(text, 100, "")
+		assert.True(t, ok)
+		assert.Equal(t, []string{}, result, "text %q", text)
+
+		result, ok = MarkdownSplit(text, 2, "")
+		assert.True(t, ok)
+		assert.Equal(t, []string{}, result, "text %q", text)
+	}
+
+	result, ok := SplitIntoN("", 2, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{}, result)
+
+	assert.Equal(t, []string{}, SimpleSplit("", 100, ""))
+	assert.Equal(t, []string{}, SimpleSplit("", 1, "-"))
+	assert.Equal(t, []string{"\n"}, SimpleSplit("\n", 100, ""))
+	assert.Equal(t, []string{"   ", "  "}, SimpleSplit("     ", 3, ""))
+}
+
+func TestSplitNonPositiveMax(t *testing.T) {
+	t.Parallel()
+
+	for _, max := range []int{0, -5} {
+		for _, text := range []string{"", "Some basic comment", "# Title\n\nSome **bold** text."} {
+			result, ok := MarkdownSplit(text, max, "")
+			assert.False(t, ok)
+			assert.Nil(t, result)
+
+			assert.Nil(t, SimpleSplit(text, max, ""))
+		}
+	}
+}
+
+func TestBuildChunks(t *testing.T) {
+	t.Parallel()
+
+	chunks, ok := buildChunks(nil, "Some text", 4, nil, DefaultOptions())
+	assert.True(t, ok)
+
+	contents := make([]string, len(chunks))
+	for i, c := range chunks {
+		contents[i] = c.content
+	}
+	assert.Equal(t, []string{"Some", " tex", "t"}, contents)
+
+	// without room for the contents it fails, instead of dropping them
+	for _, chunkLen := range []int{0, -1} {
+		_, ok = buildChunks(nil, "Some text", chunkLen, nil, DefaultOptions())
+		assert.False(t, ok)
+	}
+}
+
+func TestMinFeasibleMax(t *testing.T) {
+	t.Parallel()
+
+	plain := "Some basic comment that is long enough to be split."
+	nested := "<div><section><article><p>Some basic comment that is long enough to be split.</p></article></section></div>\n"
+
+	plainMax := MinFeasibleMax(plain, "")
+	nestedMax := MinFeasibleMax(nested, "")
+
+	assert.Equal(t, 1, plainMax)
+	assert.Greater(t, nestedMax, plainMax)
+
+	_, ok := MarkdownSplit(nested, nestedMax, "")
+	assert.True(t, ok)
+	_, ok = MarkdownSplit(nested, nestedMax-1, "")
+	assert.False(t, ok)
+
+	assert.Greater(t, MinFeasibleMax(plain, "[...]"), plainMax)
+}
+
+func TestTruncate(t *testing.T) {
+	t.Parallel()
+
+	result, truncated := Truncate("**Bold text that goes on and on** and then some.\n", 20, "...")
+	assert.True(t, truncated)
+	assert.Equal(t, "**Bold text tha**...", result)
+
+	// what doesn't fit is cut inside of the emphasis, rather than left out whole
+	result, truncated = Truncate("Some **bold text that goes on and on** here", 20, "…")
+	assert.True(t, truncated)
+	assert.Equal(t, "Some **bold tex**…", result)
+
+	result, truncated = Truncate("```go\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n```\n\nAfter.\n", 30, "...")
+	assert.True(t, truncated)
+	assert.Equal(t, "```go\nfunc main() {\n```\n...", result)
+
+	// the heading isn't numbered as a title
+	result, truncated = Truncate("# Title\n\nSome text.\n\nMore text that is long enough.\n", 30, "…")
+	assert.True(t, truncated)
+	assert.Equal(t, "# Title\n\nSome text.…", result)
+
+	result, truncated = Truncate("Short text.", 20, "...")
+	assert.False(t, truncated)
+	assert.Equal(t, "Short text.", result)
+
+	result, truncated = Truncate("Short text.", 3, "...")
+	assert.True(t, truncated)
+	assert.Equal(t, "", result)
+}
+
+func TestMarkdownSplitLineEndings(t *testing.T) {
+	t.Parallel()
+
+	text := "# Title\r\n\r\nFirst paragraph of the text.\r\n\r\n```go\r\nfmt.Println(\"hello\")\r\nfmt.Println(\"world\")\r\n```\r\n"
+
+	result, ok := MarkdownSplit(text, 50, "")
+	assert.True(t, ok)
+	assert.Greater(t, len(result), 1)
+
+	for _, cm := range result {
+		assert.NotContains(t, cm, "\r")
+		assert.LessOrEqual(t, len(cm), 50)
+	}
+
+	opts := DefaultOptions()
+	opts.RestoreCRLF = true
+
+	result, ok = MarkdownSplitOpts(text, 50, "", opts)
+	assert.True(t, ok)
+
+	for _, cm := range result {
+		assert.Equal(t, strings.Count(cm, "\n"), strings.Count(cm, "\r\n"))
+		assert.LessOrEqual(t, len(cm), 50)
+	}
+
+	opts = DefaultOptions()
+	opts.OutputLineEnding = CRLF
+
+	lf := strings.ReplaceAll(text, "\r\n", "\n")
+
+	result, ok = MarkdownSplitOpts(lf, 50, "", opts)
+	assert.True(t, ok)
+	assert.Greater(t, len(result), 1)
+
+	for _, cm := range result {
+		assert.Contains(t, cm, "\r\n")
+		assert.Equal(t, strings.Count(cm, "\n"), strings.Count(cm, "\r\n"))
+		assert.LessOrEqual(t, len(cm), 50)
+	}
+
+	// the line endings of the text are kept, whatever they were
+	opts.OutputLineEnding = PreserveLineEnding
+
+	result, ok = MarkdownSplitOpts(lf, 50, "", opts)
+	assert.True(t, ok)
+	assert.NotContains(t, strings.Join(result, ""), "\r")
+
+	result, ok = MarkdownSplitOpts(text, 50, "", opts)
+	assert.True(t, ok)
+	assert.Contains(t, strings.Join(result, ""), "\r\n")
+}
+
+func TestMarkdownSplitTabWidth(t *testing.T) {
+	t.Parallel()
+
+	text := "Code:\n\n```go\nfunc main() {\n\tif ok {\n\t\tfmt.Println(\"hi\")\n\t}\n}\n```\n"
+
+	opts := DefaultOptions()
+	opts.TabWidth = 4
+
+	result, ok := MarkdownSplitOpts(text, 30, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Code:",
+		"```go\nfunc main() {\n```\n",
+		"```go\n    if ok {\n```\n",
+		"```go\n        fmt.Println\n```\n",
+		"```go\n(\"hi\")\n    }\n}\n```\n",
+	}, result)
+
+	for _, cm := range result {
+		assert.NotContains(t, cm, "\t")
+		assert.LessOrEqual(t, len(cm), 30)
+	}
+
+	assert.Equal(t, "a b\n  日本  c", expandTabs("a\tb\n\t日本\tc", 2))
+}
+
+func TestMarkdownSplitNormalize(t *testing.T) {
+	t.Parallel()
+
+	// the same text with its accents precomposed and decomposed
+	nfc := "Le café est très réputé.\n\nLe résumé de l'été."
+	nfd := "Le cafe\u0301 est tre\u0300s re\u0301pute\u0301.\n\nLe re\u0301sume\u0301 de l'e\u0301te\u0301."
+
+	nfcResult, _ := MarkdownSplit(nfc, 20, "")
+	nfdResult, _ := MarkdownSplit(nfd, 20, "")
+	assert.NotEqual(t, nfcResult, nfdResult)
+
+	opts := DefaultOptions()
+	opts.Normalize = true
+
+	nfcResult, ok := MarkdownSplitOpts(nfc, 20, "", opts)
+	assert.True(t, ok)
+	nfdResult, ok = MarkdownSplitOpts(nfd, 20, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, nfcResult, nfdResult)
+
+	opts.NormalizationForm = norm.NFD
+
+	nfdResult, ok = MarkdownSplitOpts(nfc, 20, "", opts)
+	assert.True(t, ok)
+	assert.Contains(t, strings.Join(nfdResult, ""), "cafe\u0301")
+}
+
+func TestMarkdownSplitPreserveBlankLines(t *testing.T) {
+	t.Parallel()
+
+	text := "First paragraph here.\n\n\n\nSecond paragraph here.\n\n\n\nThird one.\n"
+
+	opts := DefaultOptions()
+	opts.PreserveBlankLines = true
+
+	result, ok := MarkdownSplitOpts(text, 40, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"First paragraph here.",
+		"\n\n\n\nSecond paragraph here.\n\n\n\nThird one.",
+	}, result)
+}
+
+func TestMarkdownSplitPreserveEdgeWhitespace(t *testing.T) {
+	t.Parallel()
+
+	text := "\nFirst paragraph here.\n\nSecond paragraph, longer.\n"
+
+	// the blocks are rebuilt without the whitespace around them
+	result, ok := MarkdownSplit(text, 30, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"First paragraph here.", "Second paragraph, longer."}, result)
+
+	opts := DefaultOptions()
+	opts.PreserveEdgeWhitespace = true
+
+	result, ok = MarkdownSplitOpts(text, 30, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"\nFirst paragraph here.", "Second paragraph, longer.\n"}, result)
+
+	// the indentation of the first line is left out, and the line break of the code block isn't doubled
+	result, ok = MarkdownSplitOpts("\n\n  Some text that goes on.\n\n```\ncode\n```\n", 30, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"\n\nSome text that goes on.", "```\ncode\n```\n"}, result)
+
+	// they are left out when they don't fit
+	result, ok = MarkdownSplitOpts(text, 21, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, "First paragraph here.", result[0])
+}
+
+func TestMarkdownSplitSetextHeadings(t *testing.T) {
+	t.Parallel()
+
+	text := "Main title\n==========\n\nSome text in the document.\n\nSection\n-------\n\nMore text here.\n"
+
+	opts := DefaultOptions()
+	opts.PreserveSetextHeadings = true
+
+	result, ok := MarkdownSplitOpts(text, 60, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Main title (1/4)\n==========\n\nSome text in the do",
+		"Main title (2/4)\n==========\n\ncument.",
+		"Main title (3/4)\n==========\n\nSection\n-------\n\n",
+		"Main title (4/4)\n==========\n\nMore text here.",
+	}, result)
+}
+
+func TestMarkdownSplitNestedEmphasis(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		markdown string
+		chunks   []string
+	}{
+		"bold_inside_italic": {
+			"Text with _italic **bold** and more italic words here_ end.\n",
+			[]string{"Text with _italic **bold**_", " _and more italic words here_", " end."},
+		},
+		"italic_inside_bold": {
+			"Text with **bold _italic_ and more bold words here** end.\n",
+			[]string{"Text with **bold _italic_**", " **and more bold words here**", " end."},
+		},
+		"bold_italic": {
+			"Some ***bold italic words that get split*** end.\n",
+			[]string{"Some ", "**_bold italic words that g_**", "**_et split_** end."},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("case_%s", name), func(t *testing.T) {
+			t.Parallel()
+			result, ok := MarkdownSplit(tc.markdown, 30, "")
+			assert.True(t, ok)
+			assert.Equal(t, tc.chunks, result)
+			// the whitespace at the edges of the chunks is outside of the delimiters, so they still render
+			assert.NoError(t, ValidateChunks(result))
+		})
+	}
+}
+
+func TestMarkdownSplitWrapperNesting(t *testing.T) {
+	t.Parallel()
+
+	// a link inside of bold inside of strikethrough closes in the reverse order it opens
+	result, ok := MarkdownSplit("~~Struck **bold [a link text here](https://x.io) more** end~~\n", 40, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"~~Struck **bold**~~ ",
+		"~~**[a link text here](https://x.io)**~~",
+		" ~~**more** end~~",
+	}, result)
+	assert.NoError(t, ValidateChunks(result))
+
+	// and so do html tags, from the first opened to the last
+	result, ok = MarkdownSplit("<a><b><i>Some text that gets split</i></b></a>", 30, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"<a><b><i>Some text</i></b></a>",
+		"<a><b><i> that get</i></b></a>",
+		"<a><b><i>s split</i></b></a>",
+	}, result)
+}
+
+func TestMarkdownSplitNestedSameHTMLTags(t *testing.T) {
+	t.Parallel()
+
+	text := `<span class="a">Outer text <span class="b">inner text that is long</span> outer again</span>` + "\n"
+
+	result, ok := MarkdownSplit(text, 70, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		`<span class="a">Outer text </span>`,
+		`<span class="a"><span class="b">inner text that is long</span></span>`,
+		`<span class="a"> outer again</span>`,
+	}, result)
+
+	assert.Equal(t, "</span>", getHTMLClosingTag(`<span class="a">`))
+	assert.Equal(t, "</DIV>", getHTMLClosingTag("<DIV>"))
+}
+
+func TestMarkdownSplitHTMLComments(t *testing.T) {
+	t.Parallel()
+
+	text := "Some text with <!-- an inline note --> in the middle of it.\n\n<!--\nA long comment\nthat spans lines <b>\n-->\n\nAfter it.\n"
+
+	result, ok := MarkdownSplit(text, 45, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Some text with <!-- an inline note -->",
+		" in the middle of it.",
+		"<!--\nA long comment\nthat spans lines <b>\n-->",
+		"After it.",
+	}, result)
+
+	// the multi-line comment doesn't fit
+	_, ok = MarkdownSplit(text, 30, "")
+	assert.False(t, ok)
+}
+
+func TestMarkdownSplitHTMLAttributes(t *testing.T) {
+	t.Parallel()
+
+	text := `<span style="color:red">A long attributed span of text that is split across three chunks.</span>` + "\n"
+
+	result, ok := MarkdownSplit(text, 60, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		`<span style="color:red">A long attributed span of tex</span>`,
+		`<span style="color:red">t that is split across three </span>`,
+		`<span style="color:red">chunks.</span>`,
+	}, result)
+}
+
+func TestMarkdownSplitRawHTMLBlocks(t *testing.T) {
+	t.Parallel()
+
+	script := "<script>\nif (a < b && *c*) { x = [d](e); }\n# not a heading\n</script>"
+	text := "Intro paragraph here.\n\n" + script + "\n\nAfter the block comes this.\n"
+
+	result, ok := MarkdownSplit(text, 80, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Intro paragraph here.",
+		script,
+		"After the block comes this.",
+	}, result)
+
+	// it's never cut, even if it doesn't fit in a chunk
+	detailed := SplitDetailed(text, 60, "", DefaultOptions())
+	assert.True(t, detailed.Fallback)
+	assert.Equal(t, ReasonRawHTMLTooLong, detailed.ReasonCode)
+}
+
+func TestSplitHTMLEntities(t *testing.T) {
+	t.Parallel()
+
+	// the named, decimal and hex entities would all be broken at a cut every 10 bytes
+	assert.Equal(t, []string{"Tomas ", "&amp; Jerr", "y and ", "&#169; or ", "&#x1F600;"},
+		SimpleSplit("Tomas &amp; Jerry and &#169; or &#x1F600;", 10, ""))
+
+	// things that only look like entities are cut anywhere
+	assert.Equal(t, []string{"a & b & ", "c"}, SimpleSplit("a & b & c", 8, ""))
+
+	text := "<div>\nTom &amp; Jerry &#169; and &#x1F600; smile\n</div>\n"
+
+	result, ok := MarkdownSplit(text, 20, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"<div>\nTom </div>",
+		"<div>&amp; Jer</div>",
+		"<div>ry &#169;</div>",
+		"<div> and </div>",
+		"<div>&#x1F600;</div>",
+		"<div> smile\n</div>",
+	}, result)
+}
+
+func TestSplitEmojiShortcodes(t *testing.T) {
+	t.Parallel()
+
+	// both shortcodes would be cut at a cut every 10 bytes
+	assert.Equal(t, []string{"Great job ", ":tada: ", ":thumbsup:", " all"}, SimpleSplit("Great job :tada: :thumbsup: all", 10, ""))
+
+	// a lone colon isn't a shortcode, so it's cut anywhere
+	assert.Equal(t, []string{"Note: this", " is a: tes", "t."}, SimpleSplit("Note: this is a: test.", 10, ""))
+
+	result, ok := MarkdownSplit("We shipped it today :tada: and :+1: to all.\n", 23, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"We shipped it today ", ":tada: and :+1: to all."}, result)
+}
+
+func TestMarkdownSplitEscapes(t *testing.T) {
+	t.Parallel()
+
+	text := `Some \*stars\* and \[brackets\] and a \\ backslash and \_under\_ *em*` + "\n"
+
+	result, ok := MarkdownSplit(text, 20, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		`Some \*stars\* and `,
+		`\[brackets\] and a `,
+		`\\ backslash and \_`,
+		`under\_ _em_`,
+	}, result)
+
+	// a chunk that can't even hold an escape
+	_, ok = MarkdownSplit(`\*\*\*`, 1, "")
+	assert.False(t, ok)
+}
+
+func TestMarkdownSplitKeepURLsWhole(t *testing.T) {
+	t.Parallel()
+
+	text := "Read [the docs](https://example.com/a/very/long/path/to/the/documentation) before, " +
+		"and see <https://example.com/another/long/autolink/url> too.\n"
+
+	opts := DefaultOptions()
+	opts.PreserveAutolinks = true
+
+	// the urls are cut by the fallback
+	_, ok := MarkdownSplitOpts(text, 30, "", opts)
+	assert.False(t, ok)
+
+	opts.KeepURLsWhole = true
+
+	result, ok := MarkdownSplitOpts(text, 30, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Read ",
+		"[the docs](https://example.com/a/very/long/path/to/the/documentation)",
+		" before, and see ",
+		"<https://example.com/another/long/autolink/url>",
+		" too.",
+	}, result)
+
+	// the urls that fit are split as usual, so no chunk is longer than max
+	text = "Read [the docs](https://example.com/docs) before you begin, and see <https://example.com/faq> for the rest.\n"
+
+	result, ok = MarkdownSplitOpts(text, 40, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Read ",
+		"[the docs](https://example.com/docs)",
+		" before you begin, and see ",
+		"<https://example.com/faq> for the rest.",
+	}, result)
+
+	for _, cm := range result {
+		assert.LessOrEqual(t, len(cm), 40, cm)
+	}
+}
+
+func TestMarkdownSplitKeepLinksInFallback(t *testing.T) {
+	t.Parallel()
+
+	// lists aren't split, so the simple split is done instead
+	text := "Some links:\n\n* [the docs](https://example.com/docs) and [the code][code]\n* ![a logo](logo.png)\n\n[code]: https://example.com/code\n"
+
+	opts := DefaultOptions()
+
+	result := SplitDetailed(text, 40, "", opts)
+	assert.True(t, result.Fallback)
+	assert.Equal(t, "Some links:\n\n* [the docs](https://exampl", result.Chunks[0])
+
+	opts.KeepLinksInFallback = true
+
+	result = SplitDetailed(text, 40, "", opts)
+	assert.True(t, result.Fallback)
+	assert.Equal(t, []string{
+		"Some links:\n\n* ",
+		"[the docs](https://example.com/docs) and",
+		" [the code][code]\n* ![a logo](logo.png)\n",
+		"\n[code]: https://example.com/code\n",
+	}, result.Chunks)
+	assert.Equal(t, [][2]int{{0, 15}, {15, 55}, {55, 95}, {95, 129}}, result.ByteRanges)
+}
+
+func TestMarkdownSplitKeepWordsWhole(t *testing.T) {
+	t.Parallel()
+
+	// lists aren't split, so the simple split is done instead
+	text := "Things to pack:\n\n* sunscreen and towels\n* a waterproofjacket\n"
+
+	opts := DefaultOptions()
+	opts.KeepWordsWhole = true
+
+	result := SplitDetailed(text, 20, "", opts)
+	assert.True(t, result.Fallback)
+	assert.Equal(t, []string{
+		"Things to pack:\n\n* ",
+		"sunscreen and towels",
+		"\n* a ",
+		"waterproofjacket\n",
+	}, result.Chunks)
+	assert.Equal(t, text, strings.Join(result.Chunks, ""))
+
+	// a word longer than a chunk is cut anyway
+	assert.Equal(t, []string{"tiny -", "enormouslylong-", "word end"}, SimpleSplitOpts("tiny enormouslylongword end", 15, "-", opts))
+	assert.Equal(t, []string{"tiny enormousl-", "ylongword end"}, SimpleSplitOpts("tiny enormouslylongword end", 15, "-", Options{}))
+}
+
+func TestMarkdownSplitKeepMentionsWhole(t *testing.T) {
+	t.Parallel()
+
+	// without the option, both of them would be cut at the boundaries
+	text := "Thanks a lot @someone for the fix in #general today.\n"
+
+	opts := DefaultOptions()
+	opts.KeepMentionsWhole = true
+
+	result, ok := MarkdownSplitOpts(text, 20, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Thanks a lot ",
+		"@someone for the fix",
+		" in #general today.",
+	}, result)
+
+	assert.Equal(t, []string{"Ping ", "@the-team-", "in ", "#ops-room"}, SimpleSplitOpts("Ping @the-team-in #ops-room", 10, "", opts))
+	assert.Equal(t, []string{"@averylong", "name"}, SimpleSplitOpts("@averylongname", 10, "", opts))
+}
+
+func TestMarkdownSplitKeepCombiningMarks(t *testing.T) {
+	t.Parallel()
+
+	// the e of café and its accent take bytes 3 to 6, so a cut at 4 falls between them
+	text := "cafe\u0301 cre\u0300me"
+
+	assert.Equal(t, []string{"cafe", "\u0301 cr", "e\u0300me"}, SimpleSplit(text, 5, ""))
+
+	opts := DefaultOptions()
+	opts.KeepCombiningMarks = true
+
+	assert.Equal(t, []string{"caf", "e\u0301 c", "re\u0300m", "e"}, SimpleSplitOpts(text, 5, "", opts))
+
+	result, ok := MarkdownSplitOpts("**"+text+"**", 9, "", opts)
+	assert.True(t, ok)
+	for _, cm := range result {
+		r, _ := utf8.DecodeRuneInString(strings.TrimLeft(cm, "*"))
+		assert.False(t, unicode.Is(unicode.Mn, r), cm)
+	}
+}
+
+func TestMarkdownSplitCodeInfo(t *testing.T) {
+	t.Parallel()
+
+	text := "Intro.\n\n```go title=\"main.go\" {1,3}\npackage main\n\nfunc main() {}\n```\n\n```go title=\"empty.go\"\n```\n\nEnd.\n"
+
+	result, ok := MarkdownSplit(text, 50, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Intro.",
+		"```go title=\"main.go\" {1,3}\npackage main\n\n```\n",
+		"```go title=\"main.go\" {1,3}\nfunc main() {}\n```\n",
+		"```go title=\"empty.go\"\n```\nEnd.",
+	}, result)
+}
+
+// failingWriter fails every write after the first n.
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		return 0, errors.New("write failed")
+	}
+
+	w.n--
+	return len(p), nil
+}
+
+func TestSplitToWriter(t *testing.T) {
+	t.Parallel()
+
+	text := "### Comment with title\n\nIncludes the title in every split."
+	expected, _ := MarkdownSplit(text, 50, "")
+
+	var buf bytes.Buffer
+	n, ok, err := SplitToWriter(&buf, text, 50, "", "\n---\n")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, len(expected), n)
+	assert.Equal(t, strings.Join(expected, "\n---\n"), buf.String())
+
+	// the first chunk is written, but not the delimiter after it
+	n, _, err = SplitToWriter(&failingWriter{n: 1}, text, 50, "", "\n---\n")
+	assert.EqualError(t, err, "write failed")
+	assert.Equal(t, 1, n)
+
+	// the chunks of a simple split are written too
+	text = "- a list\n- that isn't split\n- by default"
+	expected = SimpleSplit(text, 20, "")
+
+	buf.Reset()
+	n, ok, err = SplitToWriter(&buf, text, 20, "", "\n---\n")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, len(expected), n)
+	assert.Equal(t, strings.Join(expected, "\n---\n"), buf.String())
+}
+
+func TestSplitStream(t *testing.T) {
+	t.Parallel()
+
+	text := "# Title\n\n" + strings.Repeat("Some **bold** words in a paragraph. ", 6)
+	expected, _ := MarkdownSplit(text, 60, "…")
+
+	var buf bytes.Buffer
+	n, err := SplitStream(strings.NewReader(text), &buf, 60, "…", "\n---\n")
+	assert.NoError(t, err)
+	assert.Equal(t, len(expected), n)
+	assert.Equal(t, strings.Join(expected, "\n---\n"), buf.String())
+
+	_, err = SplitStream(iotest.ErrReader(errors.New("read failed")), &buf, 60, "", "")
+	assert.EqualError(t, err, "read failed")
+}
+
+func TestMarkdownSplitBalanced(t *testing.T) {
+	t.Parallel()
+
+	text := strings.Repeat("A long paragraph of plain words, ", 7) + "and **some bold** ones at the end."
+
+	variance := func(chunks []string) float64 {
+		mean := 0.0
+		for _, cm := range chunks {
+			mean += float64(len(cm)) / float64(len(chunks))
+		}
+
+		v := 0.0
+		for _, cm := range chunks {
+			v += (float64(len(cm)) - mean) * (float64(len(cm)) - mean) / float64(len(chunks))
+		}
+
+		return v
+	}
+
+	filled, ok := MarkdownSplit(text, 100, "")
+	assert.True(t, ok)
+
+	opts := DefaultOptions()
+	opts.Balanced = true
+
+	balanced, ok := MarkdownSplitOpts(text, 100, "", opts)
+	assert.True(t, ok)
+	assert.Len(t, balanced, len(filled))
+	assert.Less(t, variance(balanced), variance(filled))
+
+	for _, cm := range balanced {
+		assert.LessOrEqual(t, len(cm), 100)
+	}
+}
+
+func TestMarkdownSplitNestedQuotes(t *testing.T) {
+	t.Parallel()
+
+	text := "> Outer quote text here.\n>\n> > Deeply quoted text that is long enough.\n> > On two lines.\n>\n" +
+		"> Back to outer, with more text to force a split of the quote.\n"
+
+	result, ok := MarkdownSplit(text, 80, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"> Outer quote text here.\n>\n> > Deeply quoted text that is long enough.\n\n",
+		"> > On two lines.\n\n",
+		"> Back to outer, with more text to force a split of the quote.\n\n",
+	}, result)
+
+	// every line of the code is quoted, fences included
+	text = "> Some code:\n>\n> ```go\n> fmt.Println(\"a\")\n> fmt.Println(\"b\")\n> ```\n>\n> And text after it.\n\nAfter."
+
+	result, ok = MarkdownSplit(text, 40, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"> Some code:\n\n",
+		"> ```go\n> fmt.Println(\"a\")\n> ```\n\n\n",
+		"> ```go\n> fmt.Println(\"b\")\n> ```\n\n\n",
+		"> And text after it.\n\nAfter.",
+	}, result)
+}
+
+func TestMarkdownSplitAlerts(t *testing.T) {
+	t.Parallel()
+
+	text := "Intro.\n\n> [!WARNING]\n> This is a long warning that will need to be split across several chunks to fit.\n> Second line of it.\n\nAfter.\n"
+
+	result, ok := MarkdownSplit(text, 60, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Intro.\n\n",
+		"> [!WARNING]\n> This is a long warning that will need to be\n\n",
+		">  split across several chunks to fit.\n\n",
+		"> Second line of it.\n\nAfter.",
+	}, result)
+
+	assert.Equal(t, 1, strings.Count(strings.Join(result, ""), "[!WARNING]"))
+	for _, cm := range result[1:] {
+		for _, line := range strings.Split(strings.Split(cm, "\n\n")[0], "\n") {
+			assert.True(t, strings.HasPrefix(line, ">"), line)
+		}
+	}
+
+	// the marker doesn't fit along with the quote prefix
+	_, ok = MarkdownSplit(text, 14, "")
+	assert.False(t, ok)
+}
+
+func TestMarkdownSplitDetails(t *testing.T) {
+	t.Parallel()
+
+	text := "<details>\n<summary>Click to expand</summary>\n\n" +
+		"This is a long hidden section of *text* that will need to be split across chunks to fit in them.\n\n" +
+		"</details>\n\nAfter.\n"
+
+	result, ok := MarkdownSplit(text, 80, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"<details>\n<summary>Click to expand</summary>\n\nThis is a long hidden \n\n</details>",
+		"<details>\n<summary>Click to expand</summary>\n\nsection of _text_\n\n</details>",
+		"<details>\n<summary>Click to expand</summary>\n\n that will need to be \n\n</details>",
+		"<details>\n<summary>Click to expand</summary>\n\nsplit across chunks to\n\n</details>",
+		"<details>\n<summary>Click to expand</summary>\n\n fit in them.\n\n</details>After.",
+	}, result)
+}
+
+func TestMarkdownSplitReason(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		text     string
+		max      int
+		sep      string
+		expected SplitReason
+	}{
+		"ok":                 {"Some text that is split in chunks", 10, "", SplitOK},
+		"max_not_positive":   {"Some text", 0, "", ReasonMaxNotPositive},
+		"separator_too_long": {"Some text that is split in chunks", 10, "(continues...)", ReasonSeparatorTooLong},
+		"front_matter":       {"---\ntitle: A title too long for a chunk\n---\n\nSome text", 20, "", ReasonFrontMatterTooLong},
+		"lists":              {"* An item\n* Another item", 10, "", ReasonListsNotSplit},
+		"table":              {"| A | B |\n|---|---|\n| a long cell | another long cell |\n", 30, "", ReasonTableTooLong},
+		"markup":             {"```go\nfmt.Println()\n```\n", 8, "", ReasonMarkupTooLong},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, reason := MarkdownSplitReason(tc.text, tc.max, tc.sep)
+			assert.Equal(t, tc.expected, reason)
+		})
+	}
+
+	assert.Equal(t, "lists are only split with SplitLists", ReasonListsNotSplit.String())
+	assert.Equal(t, "", SplitOK.String())
+}
+
+func TestSplitOnFallback(t *testing.T) {
+	t.Parallel()
+
+	var reasons []SplitReason
+
+	opts := DefaultOptions()
+	opts.OnFallback = func(reason SplitReason) {
+		reasons = append(reasons, reason)
+	}
+
+	text := "* An item\n* Another item"
+
+	result := SplitDetailed(text, 10, "", opts)
+	assert.True(t, result.Fallback)
+	assert.Equal(t, []SplitReason{ReasonListsNotSplit}, reasons)
+
+	// the chunks are the same as without the callback
+	assert.Equal(t, SplitDetailed(text, 10, "", DefaultOptions()).Chunks, result.Chunks)
+
+	// it isn't called when the text is split as markdown
+	SplitDetailed("Some text that is split in chunks", 10, "", opts)
+	assert.Len(t, reasons, 1)
+}
+
+func TestMarkdownSplitMaxByType(t *testing.T) {
+	t.Parallel()
+
+	text := "Some prose that is long enough to need a few chunks of its own when split.\n\n" +
+		"```go\nfunc main() {\n\tfmt.Println(\"a long line of code\")\n}\n```\n\nMore prose after the code.\n"
+
+	result, ok := MarkdownSplit(text, 100, "")
+	assert.True(t, ok)
+	assert.Len(t, result, 2)
+
+	opts := DefaultOptions()
+	opts.MaxByType = map[blackfriday.NodeType]int{blackfriday.Paragraph: 40, blackfriday.CodeBlock: 200}
+
+	result, ok = MarkdownSplitOpts(text, 100, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Some prose that is long enough to need a",
+		" few chunks of its own when split.",
+		"```go\nfunc main() {\n\tfmt.Println(\"a long line of code\")\n}\n```\n",
+		"More prose after the code.",
+	}, result)
+
+	// the prose is kept in its budget, while the code takes more than it, up to max
+	assert.LessOrEqual(t, len(result[0]), 40)
+	assert.Greater(t, len(result[2]), 40)
+}
+
+func TestMarkdownSplitChunkAffixes(t *testing.T) {
+	t.Parallel()
+
+	text := strings.Repeat("Some **bold** text that goes on. ", 14)
+
+	opts := DefaultOptions()
+	opts.ChunkPrefix = "(continued) "
+	opts.ChunkSuffix = "\n\n— part {i}/{n}"
+
+	result, ok := MarkdownSplitOpts(text, 80, "", opts)
+	assert.True(t, ok)
+	assert.Greater(t, len(result), 9)
+
+	for i, cm := range result {
+		assert.True(t, strings.HasPrefix(cm, "(continued) "), cm)
+		assert.True(t, strings.HasSuffix(cm, fmt.Sprintf("\n\n— part %d/%d", i+1, len(result))), cm)
+		assert.LessOrEqual(t, len(cm), 80)
+	}
+
+	// the prefix and suffix take all the room
+	detailed := SplitDetailed(text, 25, "", opts)
+	assert.Equal(t, ReasonAffixesTooLong, detailed.ReasonCode)
+}
+
+func TestMarkdownSplitAutoCloseHTML(t *testing.T) {
+	t.Parallel()
+
+	text := "Some <b>unclosed bold text that goes on and on until the end.\n"
+
+	result, ok := MarkdownSplit(text, 30, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Some ",
+		"<b>unclosed bold text that</b>",
+		"<b> goes on and on until t</b>",
+		"<b>he end.</b>",
+	}, result)
+
+	opts := DefaultOptions()
+	opts.AutoCloseHTML = false
+
+	result, ok = MarkdownSplitOpts(text, 30, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Some <b>",
+		"unclosed bold text that goes o",
+		"n and on until the end.",
+	}, result)
+}
+
+func FuzzMarkdownSplit(f *testing.F) {
+	f.Add("# Title\n\nSome **bold** and _emphasis_ with a [link](https://example.com).", 20)
+	f.Add("* an item\n  * a nested one\n\n| a | b |\n|---|---|\n| c | d |\n", 15)
+	f.Add("<div><b>unbalanced</i> html</div></span>\n\n```go\ncode\n```\n", 10)
+	f.Add("> [!NOTE]\n> quoted\n\n<details>\n<summary>s</summary>\n\ntext\n\n</details>\n", 12)
+	f.Add("Text with a footnote[^1] and &amp; \\* escapes.\n\n[^1]: The note.\n", 8)
+	// footnotes referencing themselves, and front matter followed only by an html tag, used to hang and panic
+	f.Add("Note[^a].\n\n[^a]: See [^b].\n[^b]: See [^a].\n", 30)
+	f.Add("---\ntitle: x\n---\n<summary>", 19)
+
+	f.Fuzz(func(t *testing.T, text string, max int) {
+		// keep max small, so the text is actually split
+		max = max%200 + 1
+		if max < 1 {
+			max = -max + 1
+		}
+
+		chunks, _ := MarkdownSplit(text, max, "")
+		for _, cm := range chunks {
+			if len(cm) > max {
+				t.Fatalf("chunk longer than %d: %q", max, cm)
+			}
+		}
+	})
+}
+
+func TestMarkdownSplitKeepParagraphsWhole(t *testing.T) {
+	t.Parallel()
+
+	short := []string{"First **short** one.", "Second _short_ paragraph.", "Last [one](http://x.com)."}
+	long := "A third one, which is a lot longer than the **others** and needs splitting for sure."
+	text := short[0] + "\n\n" + short[1] + "\n\n" + long + "\n\n" + short[2] + "\n"
+
+	// without the option, the last one would begin right after the long one
+	result, ok := MarkdownSplit(text, 45, "")
+	assert.True(t, ok)
+	assert.NotContains(t, result, short[2])
+
+	opts := DefaultOptions()
+	opts.KeepParagraphsWhole = true
+
+	result, ok = MarkdownSplitOpts(text, 45, "", opts)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		short[0] + short[1],
+		"A third one, which is a lot longer than the ",
+		"**others** and needs splitting for sure.",
+		short[2],
+	}, result)
+
+	for _, cm := range result {
+		assert.LessOrEqual(t, len(cm), 45)
+	}
+}
+
+func TestMarkdownSplitLongCodeLines(t *testing.T) {
+	t.Parallel()
+
+	tokens := "token0 token1 token2 token3 token4 token5 token6 token7 token8 token9 token10 token11"
+	text := "```\n" + tokens + "\n" + strings.Repeat("日本語", 12) + "\n```\n"
+
+	result, ok := MarkdownSplit(text, 40, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"```\ntoken0 token1 token2 token3 \n```\n",
+		"```\ntoken4 token5 token6 token7 \n```\n",
+		"```\ntoken8 token9 token10 token11\n```\n",
+		"```\n日本語日本語日本語日\n```\n",
+		"```\n本語日本語日本語日本\n```\n",
+		"```\n語日本語日本語日本語\n```\n",
+		"```\n日本語日本語\n```\n",
+	}, result)
+
+	for _, cm := range result {
+		assert.True(t, utf8.ValidString(cm), cm)
+		assert.NoError(t, ValidateChunk(cm))
+	}
+}
+
+func TestSplitAnnotateFallback(t *testing.T) {
+	t.Parallel()
+
+	text := "Intro text here.\n\n* one item\n* another item that is long\n\nAnd the end of it.\n"
+
+	result := SplitDetailed(text, 60, "…", DefaultOptions())
+	assert.True(t, result.Fallback)
+	assert.NotContains(t, strings.Join(result.Chunks, ""), "<!--")
+
+	opts := DefaultOptions()
+	opts.AnnotateFallback = true
+
+	result = SplitDetailed(text, 60, "…", opts)
+	assert.True(t, result.Fallback)
+	assert.Equal(t, []string{
+		DefaultFallbackMarker + "Intro text here.…",
+		"\n\n* one item\n* another item that is long\n\nAnd the end of …",
+		"it.\n",
+	}, result.Chunks)
+	assert.Equal(t, [][2]int{{0, 16}, {16, 73}, {73, len(text)}}, result.ByteRanges)
+
+	opts.FallbackMarker = "(plain) "
+	result = SplitDetailed(text, 60, "…", opts)
+	assert.True(t, strings.HasPrefix(result.Chunks[0], "(plain) Intro"))
+	for _, cm := range result.Chunks {
+		assert.LessOrEqual(t, len(cm), 60)
+	}
+
+	// the list is split as markdown, so there's nothing to warn about
+	opts.SplitLists = true
+	result = SplitDetailed(text, 60, "…", opts)
+	assert.False(t, result.Fallback)
+	assert.NotContains(t, strings.Join(result.Chunks, ""), "(plain)")
+}
+
+func TestMarkdownSplitFirstAndContinuationPrefixes(t *testing.T) {
+	t.Parallel()
+
+	text := strings.Repeat("Some **bold** text that goes on. ", 8)
+	intro := "Here's the report of the nightly build, which failed again:\n\n"
+
+	opts := DefaultOptions()
+	opts.FirstChunkPrefix = intro
+	opts.ContinuationPrefix = "(cont.) "
+
+	result, ok := MarkdownSplitOpts(text, 100, "", opts)
+	assert.True(t, ok)
+	assert.Greater(t, len(result), 3)
+
+	for i, cm := range result {
+		if i == 0 {
+			assert.True(t, strings.HasPrefix(cm, intro), cm)
+		} else {
+			assert.True(t, strings.HasPrefix(cm, "(cont.) "), cm)
+			assert.NotContains(t, cm, intro)
+		}
+		assert.LessOrEqual(t, len(cm), 100)
+	}
+
+	// a first prefix shorter than the rest doesn't take any more room
+	opts.FirstChunkPrefix = "> "
+	result, ok = MarkdownSplitOpts(text, 100, "", opts)
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(result[0], "> Some"), result[0])
+	assert.True(t, strings.HasPrefix(result[1], "(cont.) "), result[1])
+	assert.Greater(t, len(result[1]), 100-len(intro))
+}
+
+func TestSimpleSplitUnique(t *testing.T) {
+	t.Parallel()
+
+	// the separator is in the text, so splitting the chunks joined by it wouldn't give them back
+	text := "Wait… what… really… yes, really."
+	chunks := SimpleSplit(text, 14, "…")
+	assert.Len(t, strings.Split(strings.Join(chunks, ""), "…"), 7)
+
+	chunks, sep := SimpleSplitUnique(text, 14, "…")
+	assert.Equal(t, "……", sep)
+	assert.Equal(t, []string{"Wait… ……", "what… ……", "really……", "… yes,……", " really."}, chunks)
+	assert.Len(t, strings.Split(strings.Join(chunks, ""), sep), len(chunks))
+
+	chunks, sep = SimpleSplitUnique("no dashes here", 10, "--")
+	assert.Equal(t, "--", sep)
+	assert.Equal(t, SimpleSplit("no dashes here", 10, "--"), chunks)
+
+	// it can't grow any longer
+	chunks, _ = SimpleSplitUnique("a-b-c-d", 3, "-")
+	assert.Nil(t, chunks)
+}
+
+func TestLineSplit(t *testing.T) {
+	t.Parallel()
+
+	text := "2024-01-01 started\n2024-01-01 loading the config\nok\n2024-01-02 serving on :8080\n2024-01-02 stopped\n"
+
+	result, ok := LineSplit(text, 50, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"2024-01-01 started\n2024-01-01 loading the config\n",
+		"ok\n2024-01-02 serving on :8080\n2024-01-02 stopped\n",
+	}, result)
+	assert.Equal(t, text, strings.Join(result, ""))
+
+	result, ok = LineSplit(text, 50, "…")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"2024-01-01 started\n…",
+		"2024-01-01 loading the config\nok\n…",
+		"2024-01-02 serving on :8080\n2024-01-02 stopped\n",
+	}, result)
+
+	// the line that doesn't fit is cut, but the rest are kept whole
+	result, ok = LineSplit("short\n"+strings.Repeat("x", 25)+"\nshort again", 10, "")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"short\n", "xxxxxxxxxx", "xxxxxxxxxx", "xxxxx\n", "short agai", "n"}, result)
+
+	result, ok = LineSplit("fits\nwhole", 10, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"fits\nwhole"}, result)
+
+	result, ok = LineSplit("a\nb", 0, "")
+	assert.False(t, ok)
+	assert.Nil(t, result)
+}
+
+func TestPlainTextSplit(t *testing.T) {
+	t.Parallel()
+
+	text := "# Release notes\n\nSome **bold** and _italic_ words, a [link](http://example.com) and `code`.\n\n" +
+		"* first item\n* second ~~item~~\n\n```go\nfmt.Println(\"日本語\")\n```\n"
+
+	result := PlainTextSplit(text, 30, "")
+	assert.Equal(t, []string{
+		"Release notes\n\nSome bold and i",
+		"talic words, a link and code.\n",
+		"\nfirst item\nsecond item\n\nfmt.P",
+		"rintln(\"日本語\")",
+	}, result)
+
+	for _, cm := range result {
+		assert.LessOrEqual(t, len(cm), 30)
+		assert.True(t, utf8.ValidString(cm), cm)
+		for _, marker := range []string{"#", "**", "_", "[", "](", "http://", "`", "* ", "~~"} {
+			assert.NotContains(t, cm, marker)
+		}
+	}
+
+	// the characters aren't broken
+	assert.Equal(t, []string{"日", "本", "語"}, PlainTextSplit("**日本語**", 4, ""))
+
+	assert.Nil(t, PlainTextSplit(text, 0, ""))
+}
+
+func TestMarkdownSplitCodeSpans(t *testing.T) {
+	t.Parallel()
+
+	text := "Use ``a ` tick and more text in code`` please.\n"
+
+	result, ok := MarkdownSplit(text, 20, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"Use ",
+		"`` a ` tick and m ``",
+		"`` ore text in co ``",
+		"`` de `` please.",
+	}, result)
+
+	// the runs of backticks in the contents are outnumbered by the fence of every chunk
+	text = "Run ```` a ``` b `` c ` d in a long code span ```` to see."
+
+	result, ok = MarkdownSplit(text, 24, "")
+	assert.True(t, ok)
+	assert.Greater(t, len(result), 2)
+
+	for _, cm := range result {
+		assert.LessOrEqual(t, len(cm), 24)
+		if strings.HasPrefix(cm, "`") {
+			assert.True(t, strings.HasPrefix(cm, "```` "), cm)
+		}
+	}
+
+	// the line breaks of a code span are kept in it, instead of making it a code block
+	result, ok = MarkdownSplit("Some text with `code that\nspans two lines` inside of a paragraph.\n", 40, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"Some text with ", "`code that\nspans two lines`", " inside of a paragraph."}, result)
+
+	// and so is its first line, which isn't the language of a block, even when the code begins with its letters
+	result, ok = MarkdownSplit("Some text with ```go\ngopher := 1``` in it and more.\n", 30, "")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"Some text with ", "`go\ngopher := 1`", " in it and more."}, result)
+	assert.NoError(t, ValidateChunks(result))
+}
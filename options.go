@@ -0,0 +1,238 @@
+package mdsplit
+
+import (
+	"github.com/russross/blackfriday/v2"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Options tweaks the behavior of MarkdownSplitOpts.
+// Use DefaultOptions to get the options MarkdownSplit uses and override the fields you need.
+type Options struct {
+	// Extensions are the blackfriday extensions used to parse the markdown, on top of
+	// Strikethrough, which is always enabled.
+	Extensions blackfriday.Extensions
+
+	// LengthMode defines how lengths are measured against max, Bytes by default.
+	LengthMode LengthMode
+
+	// Rebalance reduces the effective chunk size after the initial split when the last chunk
+	// would be smaller than a quarter of max, so the content gets distributed more evenly
+	// between the same amount of chunks. No chunk will ever exceed max.
+	Rebalance bool
+
+	// Balanced evens out the length of all the chunks, instead of filling every chunk up to max before
+	// beginning the next one. The amount of chunks is the same, and no chunk will ever exceed max either.
+	Balanced bool
+
+	// PreserveFrontMatter detects a leading YAML front matter block (delimited by "---" lines)
+	// and keeps it intact, attached to the first chunk if it fits or as a chunk on its own.
+	PreserveFrontMatter bool
+
+	// InlineReferenceLinks turns reference-style links into inline ones, like [text](url).
+	// If disabled, they are kept as [text][ref] and the [ref]: url definition is appended
+	// to every chunk using it.
+	InlineReferenceLinks bool
+
+	// SplitLists splits lists item by item, repeating the item marker (and the checkbox of
+	// task list items) in every chunk an item spans. If disabled, documents containing lists
+	// fallback to simple split.
+	SplitLists bool
+
+	// KeepParagraphsWhole begins a new chunk at a paragraph, instead of filling the current one with the first
+	// part of it, when the whole paragraph doesn't fit in the current chunk but does in one of its own. Only the
+	// paragraphs longer than a chunk are split.
+	KeepParagraphsWhole bool
+
+	// PreferRuleBreaks ends a chunk right after every horizontal rule, so the chunks align
+	// to the sections they delimit.
+	PreferRuleBreaks bool
+
+	// BreadcrumbTitles titles every chunk with the chain of the most recent headings at its
+	// position, like "# Chapter > ## Section (3/12)", instead of only the first heading.
+	BreadcrumbTitles bool
+
+	// SplitAtHeadings begins a new chunk at every heading of this level or above (1 being the top),
+	// so content from different sections is never merged in the same chunk. 0 disables it.
+	SplitAtHeadings int
+
+	// PreserveAutolinks keeps the links written between angle brackets, like <http://www.example.com>,
+	// in that form instead of rewriting them as inline links. They are never cut.
+	// Requires the blackfriday.Autolink extension.
+	PreserveAutolinks bool
+
+	// Math keeps the math expressions whole: display ones ($$...$$) get their $$ fences reopened in every
+	// chunk, like code blocks, and inline ones ($...$) are never cut.
+	Math bool
+
+	// Lossless keeps the text between markup exactly as it was, including the blank lines between
+	// paragraphs and the leading and trailing whitespace, so a document with no markup is reproduced
+	// byte for byte by joining its chunks. Markup is rebuilt by the wrappers as usual.
+	Lossless bool
+
+	// PreserveBlankLines keeps the blank lines between blocks of text as they were, however many of them.
+	// The markup of the blocks is rebuilt as usual, so it's a subset of Lossless.
+	PreserveBlankLines bool
+
+	// PreserveEdgeWhitespace keeps the blank lines the text begins with and the whitespace it ends with, like its
+	// final line break, at the beginning of the first chunk and the end of the last one, as long as they fit in
+	// them. The whitespace between blocks is normalized to a single blank line anyway, unless PreserveBlankLines
+	// is set too. Lossless keeps both already.
+	PreserveEdgeWhitespace bool
+
+	// PreserveSetextHeadings keeps the headings underlined with === or --- in that style,
+	// instead of turning them into # headings. Breadcrumb titles are always made of # headings.
+	PreserveSetextHeadings bool
+
+	// MaxChunks is the maximum amount of chunks of a split, 0 meaning unlimited. A split that would
+	// produce more stops as soon as it's known, returning only the first ones along with ErrTooManyChunks.
+	MaxChunks int
+
+	// MinChunkSize lets a chunk smaller than this be merged with the previous one or, if it doesn't fit,
+	// with the next one, even if a new chunk would be started for it (like after a rule or at a heading).
+	// Sections with different breadcrumb titles are never merged.
+	MinChunkSize int
+
+	// TrimChunks removes the leading and trailing whitespace of every chunk, which is just noise in most
+	// chat platforms. The chunks left empty are dropped.
+	TrimChunks bool
+
+	// NormalizeLineEndings replaces the Windows (\r\n) and old Mac (\r) line endings by \n before splitting,
+	// as the parser only understands the latter. Then, the byte ranges of the split refer to the normalized text.
+	NormalizeLineEndings bool
+
+	// RestoreCRLF turns the line endings back into \r\n in the chunks, if the text had any and they were normalized.
+	// It's the same as an OutputLineEnding of PreserveLineEnding.
+	RestoreCRLF bool
+
+	// OutputLineEnding sets the line endings of the chunks: LF by default, CRLF to turn all of them into \r\n,
+	// or PreserveLineEnding to use \r\n if the text had any, like RestoreCRLF. Room is left for the \r in
+	// every chunk, so they never exceed max either.
+	OutputLineEnding LineEnding
+
+	// TabWidth expands the tabs to spaces up to the next multiple of it before splitting, 0 meaning they are left
+	// alone, so max reflects the width they are rendered with in monospace contexts like code blocks. As with
+	// NormalizeLineEndings, the byte ranges of the split refer to the expanded text. Indentation made of tabs
+	// only keeps its meaning in markdown with a width of 4.
+	TabWidth int
+
+	// Normalize applies the Unicode normalization of NormalizationForm (NFC by default) to the text before
+	// splitting, so the same visible text has the same length whether its accents were composed or not. As with
+	// NormalizeLineEndings, the byte ranges of the split refer to the normalized text.
+	Normalize         bool
+	NormalizationForm norm.Form
+
+	// KeepURLsWhole never cuts the destination of a link or an autolink, as it's useless once cut. If one
+	// doesn't fit in a chunk, the whole link is put in a chunk of its own, even if it's longer than max.
+	KeepURLsWhole bool
+
+	// KeepLinksInFallback keeps the links whole when the text can't be split as markdown and a simple
+	// split is done instead, cutting the text right before them. Only links longer than a chunk are cut.
+	KeepLinksInFallback bool
+
+	// KeepWordsWhole cuts the text at the last whitespace that fits when a simple split is done instead, so no
+	// word is broken across chunks. Only words longer than a chunk are cut.
+	KeepWordsWhole bool
+
+	// MaxByType sets a max smaller than the one of the split for the contents of some types of nodes, like
+	// blackfriday.Paragraph, so prose can be kept in shorter chunks than code. A node without one of its own
+	// takes the one of its innermost ancestor that has it, or the max of the split. A chunk with contents
+	// of several types is limited by the smallest of their maxes.
+	MaxByType map[blackfriday.NodeType]int
+
+	// ChunkPrefix and ChunkSuffix are added around every chunk (the separator included), like "(continued) "
+	// or "\n\n— part {i}/{n}", where {i} is replaced by the number of the chunk, starting at 1, and {n} by the
+	// total. Room is left for them in every chunk, so they never exceed max either.
+	ChunkPrefix string
+	ChunkSuffix string
+
+	// FirstChunkPrefix and ContinuationPrefix replace ChunkPrefix in the first chunk and in the rest of them, when
+	// set, like a full introduction in the first one and a brief "(cont.) " in the others. The room of the first
+	// one is only reserved in every chunk when the first chunk wouldn't fit along with it otherwise.
+	FirstChunkPrefix   string
+	ContinuationPrefix string
+
+	// AutoCloseHTML closes the html tags still open at the end of every chunk, and reopens them in the next one,
+	// even if they weren't closed in the text. Otherwise, the tags are passed through as they are.
+	AutoCloseHTML bool
+
+	// NumberTitlesPerSection restarts the numbering of the breadcrumb titles at every top-level heading, so the
+	// chunks of every section are counted on their own, like "# Section B (1/2)". It only has effect along
+	// with BreadcrumbTitles.
+	NumberTitlesPerSection bool
+
+	// TitleRenderer writes the title of the chunks instead of the default "# Title (index/total)", given the
+	// heading or breadcrumb it's made of and the number of the chunk, starting at 1, along with the total,
+	// so they can be written like "# Title (01/12)" or "# Title, one of three". The line break after it is
+	// added as usual. The room for it is measured with both numbers as 1, so it should be about as long
+	// whatever they are.
+	TitleRenderer func(base string, index, total int) string
+
+	// AnnotateFallback puts FallbackMarker (DefaultFallbackMarker if it's empty) at the beginning of the first
+	// chunk when the text can't be split as markdown and a simple split is done instead, so the readers know the
+	// formatting may be broken. Room is left for it in the first chunk, so it never exceeds max either.
+	AnnotateFallback bool
+	FallbackMarker   string
+
+	// OnFallback is called with the reason whenever the text can't be split as markdown and a simple split is
+	// done instead, like SplitResult.Fallback tells, so it can be tracked which features defeat the split most.
+	// It's called once per split, and it doesn't change the chunks.
+	OnFallback func(reason SplitReason)
+
+	// KeepCombiningMarks never cuts a letter apart from the combining marks after it, like the accent of an é
+	// written as an e followed by a combining acute accent, which would be left on its own at the beginning of
+	// the next chunk. LengthMode Graphemes and DisplayWidth never do it anyway. It applies to the simple split too.
+	KeepCombiningMarks bool
+
+	// KeepMentionsWhole never cuts the mentions and hashtags, like @user or #channel, backing the cut up to
+	// before them, as half of one may notify someone else or link to another channel. It applies to the simple
+	// split too. Only the ones that don't fit in a chunk are cut.
+	KeepMentionsWhole bool
+
+	// WikiLinks keeps the wiki-style links, like [[Page]] or [[Page|Alias]], in a single chunk, as the parser
+	// leaves them as plain text that would be cut anywhere. Only the ones that don't fit in a chunk are cut.
+	WikiLinks bool
+
+	// untitled keeps the first heading as it is instead of making it the title of the chunks, for Truncate,
+	// where only the first chunk is kept and numbering it makes no sense.
+	untitled bool
+
+	// fillFirst cuts what doesn't fit in the first chunk so its beginning fills it, for Truncate, instead of
+	// leaving it for the next chunk whole.
+	fillFirst bool
+
+	// emit is called with every chunk as soon as it's assembled, along with its index and the total, instead of
+	// returning them, for MarkdownSplitFunc. The chunks left aren't assembled once it returns an error.
+	emit func(index, total int, chunk string) error
+
+	// parser parses the markdown, blackfridayParser if it's nil.
+	parser parser
+}
+
+// DefaultFallbackMarker is the marker Options.AnnotateFallback puts at the beginning of a simple split by default,
+// a comment that isn't rendered in most platforms.
+const DefaultFallbackMarker = "<!-- split: formatting not preserved -->\n"
+
+// DefaultOptions returns the options used by MarkdownSplit.
+func DefaultOptions() Options {
+	return Options{
+		Extensions:           blackfriday.FencedCode | blackfriday.Tables | blackfriday.Autolink | blackfriday.Footnotes,
+		PreserveFrontMatter:  true,
+		InlineReferenceLinks: true,
+		NormalizeLineEndings: true,
+		AutoCloseHTML:        true,
+	}
+}
+
+// LineEnding is the line ending of the chunks.
+type LineEnding int
+
+const (
+	// LF ends the lines with \n, as the split writes them.
+	LF LineEnding = iota
+
+	// CRLF ends the lines with \r\n, as Windows does.
+	CRLF
+
+	// PreserveLineEnding ends the lines with \r\n if the text had any, or with \n otherwise.
+	PreserveLineEnding
+)
@@ -0,0 +1,239 @@
+package mdsplit
+
+import "github.com/russross/blackfriday/v2"
+
+// parser parses the markdown into the tree of nodes walked by the split, so the split doesn't depend on the
+// library behind it. Blackfriday is the one used by default, and another one (like goldmark) can be plugged in
+// by building the same tree out of its own. The extensions tell which syntax is recognized on top of the basic
+// one, like tables or footnotes.
+type parser interface {
+	parse(text string, extensions blackfriday.Extensions) *mdNode
+}
+
+// nodeType is the type of a node of the tree.
+type nodeType int
+
+const (
+	documentNode nodeType = iota
+	blockQuoteNode
+	listNode
+	itemNode
+	paragraphNode
+	headingNode
+	horizontalRuleNode
+	emphNode
+	strongNode
+	delNode
+	linkNode
+	imageNode
+	textNode
+	htmlBlockNode
+	codeBlockNode
+	softbreakNode
+	hardbreakNode
+	codeNode
+	htmlSpanNode
+	tableNode
+	tableCellNode
+	tableHeadNode
+	tableBodyNode
+	tableRowNode
+)
+
+// cellAlignment is the alignment of the cells of a table column.
+type cellAlignment int
+
+const (
+	alignNone cellAlignment = iota
+	alignLeft
+	alignCenter
+	alignRight
+)
+
+// mdNode is a node of the tree of a markdown document, with the data the split needs of every type of node.
+type mdNode struct {
+	typ                                       nodeType
+	parent, firstChild, lastChild, prev, next *mdNode
+	literal                                   []byte // the contents of a leaf, like a text, a code block or an html tag
+	level                                     int    // the level of a heading
+	destination, title                        []byte // the ones of a link or an image
+	noteID                                    int    // the number of a footnote reference, 0 if it isn't one
+	ordered                                   bool   // whether the item is one of an ordered list
+	bulletChar, delimiter                     byte   // the bullet of an item of an unordered list, and the delimiter of an ordered one
+	tight                                     bool   // whether the list has no blank lines between its items
+	footnotesList                             bool   // whether the list is the one of the footnote definitions
+	fenced                                    bool   // whether the code block is a fenced one
+	info                                      []byte // the info string of a fenced code block
+	align                                     cellAlignment
+}
+
+// appendChild adds the child as the last one of the node.
+func (n *mdNode) appendChild(child *mdNode) {
+	child.parent = n
+	if n.lastChild == nil {
+		n.firstChild = child
+	} else {
+		n.lastChild.next = child
+		child.prev = n.lastChild
+	}
+	n.lastChild = child
+}
+
+// isContainer tells whether the node can have children. Walk visits them twice, entering and exiting them, while
+// the leaves are only entered.
+func (n *mdNode) isContainer() bool {
+	switch n.typ {
+	case documentNode, blockQuoteNode, listNode, itemNode, paragraphNode, headingNode, emphNode, strongNode, delNode,
+		linkNode, imageNode, tableNode, tableHeadNode, tableBodyNode, tableRowNode, tableCellNode:
+		return true
+	}
+
+	return false
+}
+
+// walkStatus tells walk where to go after visiting a node.
+type walkStatus int
+
+const (
+	// goToNext goes on with the next node, the first child if the node is being entered.
+	goToNext walkStatus = iota
+	// skipChildren goes on with the next sibling of the node, without entering its children nor exiting it.
+	skipChildren
+	// terminate stops walking.
+	terminate
+)
+
+// walk visits the node and all its descendants in order, the containers when entering them and when exiting them,
+// the same way blackfriday does.
+func (n *mdNode) walk(visitor func(node *mdNode, entering bool) walkStatus) {
+	current, entering := n, true
+
+	for current != nil {
+		switch visitor(current, entering) {
+		case skipChildren:
+			entering = false
+		case terminate:
+			return
+		}
+
+		switch {
+		case (!current.isContainer() || !entering) && current == n:
+			current = nil
+		case entering && current.isContainer():
+			if current.firstChild != nil {
+				current = current.firstChild
+			} else {
+				entering = false
+			}
+		case current.next == nil:
+			current, entering = current.parent, false
+		default:
+			current, entering = current.next, true
+		}
+	}
+}
+
+// blackfridayParser is the parser used by default.
+type blackfridayParser struct{}
+
+func (blackfridayParser) parse(text string, extensions blackfriday.Extensions) *mdNode {
+	root := blackfriday.New(blackfriday.WithExtensions(extensions)).Parse([]byte(text))
+
+	// the nodes are rebuilt in the same order blackfriday walks them, from the root down
+	var doc, current *mdNode
+	root.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering {
+			current = current.parent
+			return blackfriday.GoToNext
+		}
+
+		n := fromBlackfriday(node)
+		if doc == nil {
+			doc = n
+		} else {
+			current.appendChild(n)
+		}
+
+		if n.isContainer() {
+			current = n
+		}
+
+		return blackfriday.GoToNext
+	})
+
+	return doc
+}
+
+// blackfridayTypes are the types of the nodes of blackfriday.
+var blackfridayTypes = map[blackfriday.NodeType]nodeType{
+	blackfriday.Document:       documentNode,
+	blackfriday.BlockQuote:     blockQuoteNode,
+	blackfriday.List:           listNode,
+	blackfriday.Item:           itemNode,
+	blackfriday.Paragraph:      paragraphNode,
+	blackfriday.Heading:        headingNode,
+	blackfriday.HorizontalRule: horizontalRuleNode,
+	blackfriday.Emph:           emphNode,
+	blackfriday.Strong:         strongNode,
+	blackfriday.Del:            delNode,
+	blackfriday.Link:           linkNode,
+	blackfriday.Image:          imageNode,
+	blackfriday.Text:           textNode,
+	blackfriday.HTMLBlock:      htmlBlockNode,
+	blackfriday.CodeBlock:      codeBlockNode,
+	blackfriday.Softbreak:      softbreakNode,
+	blackfriday.Hardbreak:      hardbreakNode,
+	blackfriday.Code:           codeNode,
+	blackfriday.HTMLSpan:       htmlSpanNode,
+	blackfriday.Table:          tableNode,
+	blackfriday.TableCell:      tableCellNode,
+	blackfriday.TableHead:      tableHeadNode,
+	blackfriday.TableBody:      tableBodyNode,
+	blackfriday.TableRow:       tableRowNode,
+}
+
+// fromBlackfriday returns a node with the data of the blackfriday one, without its relatives.
+func fromBlackfriday(node *blackfriday.Node) *mdNode {
+	n := &mdNode{
+		typ:           blackfridayTypes[node.Type],
+		literal:       node.Literal,
+		level:         node.Level,
+		destination:   node.LinkData.Destination,
+		title:         node.LinkData.Title,
+		noteID:        node.NoteID,
+		ordered:       node.ListFlags&blackfriday.ListTypeOrdered != 0,
+		bulletChar:    node.BulletChar,
+		delimiter:     node.Delimiter,
+		tight:         node.Tight,
+		footnotesList: node.IsFootnotesList,
+		fenced:        node.IsFenced,
+		info:          node.Info,
+	}
+
+	switch node.Align {
+	case blackfriday.TableAlignmentLeft:
+		n.align = alignLeft
+	case blackfriday.TableAlignmentCenter:
+		n.align = alignCenter
+	case blackfriday.TableAlignmentRight:
+		n.align = alignRight
+	}
+
+	return n
+}
+
+// nodeTypeMaxes returns the maxes of Options.MaxByType by the type of the nodes of the tree.
+func nodeTypeMaxes(maxByType map[blackfriday.NodeType]int) map[nodeType]int {
+	if len(maxByType) == 0 {
+		return nil
+	}
+
+	maxes := make(map[nodeType]int, len(maxByType))
+	for typ, max := range maxByType {
+		if t, ok := blackfridayTypes[typ]; ok {
+			maxes[t] = max
+		}
+	}
+
+	return maxes
+}
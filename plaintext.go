@@ -0,0 +1,70 @@
+package mdsplit
+
+import (
+	"strings"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// PlainTextSplit removes the markdown of the text and splits what's left like SimpleSplit, for contexts where it
+// isn't rendered, like notification previews. Only the text of the links and the alternative text of the images
+// are kept, and the code blocks lose their fences.
+// Returns nil if max isn't positive.
+func PlainTextSplit(text string, max int, sep string) []string {
+	return SimpleSplit(plainText(text), max, sep)
+}
+
+// plainText renders the markdown of the text as plain text, with the blocks apart by a blank line and the items of
+// the lists and the rows of the tables in lines of their own.
+func plainText(text string) string {
+	extensions := blackfriday.Strikethrough | DefaultOptions().Extensions
+	if hasFootnoteCycle(text) {
+		extensions &^= blackfriday.Footnotes
+	}
+
+	var sb strings.Builder
+
+	root := blackfridayParser{}.parse(text, extensions)
+	root.walk(func(node *mdNode, entering bool) walkStatus {
+		switch node.typ {
+		case textNode, codeNode:
+			sb.Write(node.literal)
+		case codeBlockNode:
+			sb.Write(node.literal)
+			sb.WriteString("\n")
+		case softbreakNode, hardbreakNode:
+			sb.WriteString("\n")
+		case paragraphNode, headingNode:
+			if !entering && !isTightItemParagraph(node) {
+				sb.WriteString("\n\n")
+			}
+		case listNode, itemNode, tableNode, tableRowNode:
+			if !entering {
+				sb.WriteString("\n")
+			}
+		case tableCellNode:
+			if !entering && node.next != nil {
+				sb.WriteString("\t")
+			}
+		}
+
+		return goToNext
+	})
+
+	return strings.TrimSpace(collapseBlankLines(sb.String()))
+}
+
+// isTightItemParagraph tells whether the paragraph is in a list item without blank lines between them, which only
+// ends with a line break.
+func isTightItemParagraph(paragraph *mdNode) bool {
+	return paragraph.parent.typ == itemNode && paragraph.parent.parent.tight
+}
+
+// collapseBlankLines leaves a single blank line wherever there are more in a row.
+func collapseBlankLines(s string) string {
+	for strings.Contains(s, "\n\n\n") {
+		s = strings.ReplaceAll(s, "\n\n\n", "\n\n")
+	}
+
+	return s
+}
@@ -0,0 +1,142 @@
+package mdsplit
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var wordRe = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// Range is the span of the text a chunk covers, from the byte at Start up to the one at End, not included.
+type Range struct {
+	Start, End int
+}
+
+// SplitWithOffsets is like MarkdownSplit, but also returns the span of the text every chunk covers, as
+// SplitResult.ByteRanges does. They are in order and never overlap, but they are only exact when it
+// fallbacks to a simple split, as the contents of the markdown ones are rebuilt.
+func SplitWithOffsets(text string, max int, sep string) ([]string, []Range, bool) {
+	result := SplitDetailed(text, max, sep, DefaultOptions())
+
+	ranges := make([]Range, len(result.ByteRanges))
+	for i, r := range result.ByteRanges {
+		ranges[i] = Range{Start: r[0], End: r[1]}
+	}
+
+	return result.Chunks, ranges, !result.Fallback && result.Err == nil
+}
+
+// simpleSplitRanges returns the offsets in the original text of the chunks of a simple split,
+// which are just consecutive portions of it followed by the separator.
+func simpleSplitRanges(chunks []string, sep string) [][2]int {
+	ranges := make([][2]int, len(chunks))
+	pos := 0
+
+	for i, chunk := range chunks {
+		size := len(chunk)
+		if i < len(chunks)-1 {
+			size -= len(sep)
+		}
+
+		ranges[i] = [2]int{pos, pos + size}
+		pos += size
+	}
+
+	return ranges
+}
+
+// markdownSplitRanges approximates the offsets in the original text of the chunks of a markdown split,
+// starting at the given offset. Their contents are rebuilt, so the words of every chunk are followed through
+// the text in order, and the chunk is taken to end after the last of them found there.
+func markdownSplitRanges(text string, offset int, chunks []string) [][2]int {
+	ranges := make([][2]int, len(chunks))
+	pos := offset
+
+	for i, chunk := range chunks {
+		end, matched := pos, 0
+
+		for _, loc := range wordRe.FindAllStringIndex(chunk, -1) {
+			// a word is only looked for as far as the text between it and the previous one found reaches
+			// in the chunk, with some room for the syntax dropped when rebuilding it, so the words the chunk
+			// repeats, like the titles, aren't found further on in the text
+			limit := end + loc[1] - matched + 16
+			if limit > len(text) {
+				limit = len(text)
+			}
+
+			word := chunk[loc[0]:loc[1]]
+			if idx := indexWord(text, end, limit, word); idx != -1 {
+				end, matched = idx+len(word), loc[1]
+			}
+		}
+
+		// the punctuation and closing delimiters after the last word belong to the chunk too
+		if tail := strings.TrimSpace(chunk[matched:]); end > pos && strings.HasPrefix(text[end:], tail) {
+			end += len(tail)
+		}
+
+		if i == len(chunks)-1 {
+			end = len(text)
+		}
+
+		ranges[i] = [2]int{pos, end}
+		pos = end
+	}
+
+	return ranges
+}
+
+// indexWord returns the offset of the first occurrence of the word in text[from:limit] that is a whole word of
+// the text, or -1 if there's none.
+func indexWord(text string, from, limit int, word string) int {
+	for from < limit {
+		idx := strings.Index(text[from:limit], word)
+		if idx == -1 {
+			return -1
+		}
+
+		start, end := from+idx, from+idx+len(word)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+
+		if !isWordRune(before) && !isWordRune(after) {
+			return start
+		}
+
+		from = start + 1
+	}
+
+	return -1
+}
+
+// isWordRune tells whether the rune is part of the words wordRe matches.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// crlfRanges moves the offsets of the ranges, taken in the text with its \r\n line endings normalized to \n,
+// to where they are in the text itself.
+func crlfRanges(text string, ranges [][2]int) {
+	// the offsets in the normalized text of the \n every \r\n is turned into
+	var crlfs []int
+	for i := 0; ; {
+		idx := strings.Index(text[i:], "\r\n")
+		if idx == -1 {
+			break
+		}
+
+		crlfs = append(crlfs, i+idx-len(crlfs))
+		i += idx + 2
+	}
+
+	if len(crlfs) == 0 {
+		return
+	}
+
+	for i, r := range ranges {
+		ranges[i] = [2]int{r[0] + sort.SearchInts(crlfs, r[0]), r[1] + sort.SearchInts(crlfs, r[1])}
+	}
+}
@@ -0,0 +1,91 @@
+package mdsplit
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	referenceDefinitionRe = regexp.MustCompile(`(?m)^ {0,3}\[([^\[\]]+)\]:[ \t]*<?([^\s>]+)>?.*$`)
+	referenceUsageRe      = regexp.MustCompile(`\[([^\[\]]+)\](\[([^\[\]]*)\])?`)
+)
+
+// referenceLink is a reference-style link as it was written in the original text.
+type referenceLink struct {
+	// suffix is what goes after the link text, like "][ref]", "][]" or "]"
+	suffix      string
+	destination string
+	definition  string
+}
+
+// referenceLinks holds the reference-style links of a text, in order of appearance.
+type referenceLinks struct {
+	usages []referenceLink
+}
+
+// findReferenceLinks looks for the reference-style links in the text that have a definition.
+func findReferenceLinks(text string) *referenceLinks {
+	type definition struct {
+		destination string
+		line        string
+	}
+
+	defs := map[string]definition{}
+	for _, match := range referenceDefinitionRe.FindAllStringSubmatch(text, -1) {
+		// footnotes look the same, but they aren't links
+		if strings.HasPrefix(match[1], "^") {
+			continue
+		}
+
+		defs[normalizeReference(match[1])] = definition{match[2], strings.TrimSpace(match[0])}
+	}
+
+	refs := &referenceLinks{}
+
+	for _, idx := range referenceUsageRe.FindAllStringSubmatchIndex(text, -1) {
+		start, end := idx[0], idx[1]
+
+		// skip images and the definitions themselves
+		if start > 0 && text[start-1] == '!' {
+			continue
+		}
+		if end < len(text) && (text[end] == ':' || text[end] == '(') {
+			continue
+		}
+
+		ref, suffix := text[idx[2]:idx[3]], "]"
+		if idx[4] != -1 {
+			suffix = "]" + text[idx[4]:idx[5]]
+			if idx[7] > idx[6] {
+				ref = text[idx[6]:idx[7]]
+			}
+		}
+
+		def, ok := defs[normalizeReference(ref)]
+		if !ok {
+			continue
+		}
+
+		refs.usages = append(refs.usages, referenceLink{suffix, def.destination, def.line})
+	}
+
+	return refs
+}
+
+// match returns the first reference-style link pointing to destination that wasn't matched yet.
+func (r *referenceLinks) match(destination string) (referenceLink, bool) {
+	for i, usage := range r.usages {
+		if usage.destination == destination {
+			r.usages = append(r.usages[:i:i], r.usages[i+1:]...)
+			return usage, true
+		}
+	}
+
+	return referenceLink{}, false
+}
+
+// normalizeReference returns the reference in the way it's matched against its definition,
+// which is case-insensitive.
+func normalizeReference(ref string) string {
+	return strings.ToLower(strings.Join(strings.Fields(ref), " "))
+}
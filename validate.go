@@ -0,0 +1,152 @@
+package mdsplit
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// The imbalances ValidateChunk reports, wrapped along with where they were found.
+var (
+	// ErrUnclosedCodeFence is the error of a fenced code block that isn't closed.
+	ErrUnclosedCodeFence = errors.New("unclosed code fence")
+	// ErrUnbalancedEmphasis is the error of an emphasis, strong or strikethrough delimiter that isn't paired.
+	ErrUnbalancedEmphasis = errors.New("unbalanced emphasis")
+	// ErrDanglingLink is the error of a link or an image cut apart from its destination.
+	ErrDanglingLink = errors.New("dangling link")
+	// ErrUnbalancedHTML is the error of an html tag that isn't closed, or a closing tag without an opening one.
+	ErrUnbalancedHTML = errors.New("unbalanced html tag")
+)
+
+// emphasisDelimiterRe matches the delimiters of emphasis left as text by the parser, as they weren't paired:
+// the double ones anywhere, and the single ones only at the edges of a word, as they are common in between.
+var emphasisDelimiterRe = regexp.MustCompile(`\*\*|__|~~|(?:^|[\s(])[*_][^\s*_]|[^\s*_][*_](?:$|[\s.,;:!?)])`)
+
+// danglingLinkRe matches the syntax of a link left as text by the parser, as its destination wasn't closed
+var danglingLinkRe = regexp.MustCompile(`\]\(`)
+
+// ValidateChunk checks that the chunk renders on its own, as a chunk of a markdown split should: its code fences,
+// emphasis and html tags must be closed, and its links must be whole. It's meant as a sanity check of the chunks
+// (or as a guard against regressions of the split), so it's a heuristic that may miss some imbalances.
+//
+// Returns the first imbalance found, wrapping one of ErrUnclosedCodeFence, ErrUnbalancedEmphasis, ErrDanglingLink
+// or ErrUnbalancedHTML, or nil if there's none.
+func ValidateChunk(chunk string) error {
+	if _, open := scanCodeFences(chunk); open != "" {
+		return fmt.Errorf("%w: %s", ErrUnclosedCodeFence, open)
+	}
+
+	extensions := blackfriday.Strikethrough | DefaultOptions().Extensions
+	if hasFootnoteCycle(chunk) {
+		extensions &^= blackfriday.Footnotes
+	}
+
+	root := blackfridayParser{}.parse(chunk, extensions)
+
+	var err error
+	// the html tags still open, from the outermost to the innermost
+	var open []string
+
+	root.walk(func(node *mdNode, entering bool) walkStatus {
+		if !entering {
+			return goToNext
+		}
+
+		literal := string(node.literal)
+
+		switch node.typ {
+		case textNode:
+			// escaped characters get a text of their own, so they are never taken as delimiters
+			if isEscapable(literal) {
+				break
+			}
+
+			if match := emphasisDelimiterRe.FindString(literal); match != "" {
+				err = fmt.Errorf("%w: %q in %q", ErrUnbalancedEmphasis, strings.TrimSpace(match), literal)
+			} else if danglingLinkRe.MatchString(literal) {
+				err = fmt.Errorf("%w: %q", ErrDanglingLink, literal)
+			}
+
+		case htmlSpanNode, htmlBlockNode:
+			for _, token := range splitHTMLTokens(literal) {
+				if !isHTMLTag(token) || isHTMLComment(token) {
+					continue
+				}
+
+				name := getHTMLTagName(token)
+
+				if isHTMLOpeningTag(token) {
+					open = append(open, name)
+					continue
+				}
+
+				if !strings.HasPrefix(token, "</") {
+					continue
+				}
+
+				if len(open) == 0 || open[len(open)-1] != name {
+					err = fmt.Errorf("%w: %s", ErrUnbalancedHTML, token)
+					break
+				}
+
+				open = open[:len(open)-1]
+			}
+		}
+
+		if err != nil {
+			return terminate
+		}
+
+		return goToNext
+	})
+
+	if err == nil && len(open) > 0 {
+		err = fmt.Errorf("%w: <%s>", ErrUnbalancedHTML, open[len(open)-1])
+	}
+
+	return err
+}
+
+// ValidateChunks checks every chunk with ValidateChunk.
+// Returns the imbalances found in all of them, along with the index of their chunks, or nil if there's none.
+func ValidateChunks(chunks []string) error {
+	var errs []error
+
+	for i, chunk := range chunks {
+		if err := ValidateChunk(chunk); err != nil {
+			errs = append(errs, fmt.Errorf("chunk %d: %w", i, err))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return chunkErrors(errs)
+}
+
+// chunkErrors are the errors of several chunks, one per line, which errors.Is matches against any of them.
+type chunkErrors []error
+
+func (e chunkErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Is tells whether any of the errors is the target.
+func (e chunkErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}